@@ -0,0 +1,97 @@
+package leonai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/igolaizola/leonai/pkg/leonardo"
+)
+
+// Exit codes used by AuthStatus.
+const (
+	ExitExpiring = 2
+	ExitExpired  = 3
+)
+
+// ExitError is an error that carries the exit code the process should return.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// AuthStatus prints the details of the session. It returns an *ExitError with
+// ExitExpiring if the session cookie expires within warn and with ExitExpired
+// if there is no valid session.
+func AuthStatus(ctx context.Context, cfg *Config, warn time.Duration) error {
+	client, _, err := newClient(cfg, false)
+	if err != nil {
+		return err
+	}
+	// Stop saves the cookie, which the server may have rotated even if the
+	// session is no longer valid.
+	defer func() {
+		if err := client.Stop(ctx); err != nil {
+			log.Printf("couldn't stop leonardo client: %v\n", err)
+		}
+	}()
+	s, err := client.Session(ctx)
+	if errors.Is(err, leonardo.ErrNoSession) {
+		fmt.Println("status: expired")
+		return &ExitError{Code: ExitExpired, Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	return sessionStatus(s, warn)
+}
+
+// sessionStatus prints the session details and returns the exit error that
+// matches its expiration.
+func sessionStatus(s *leonardo.Session, warn time.Duration) error {
+	now := time.Now()
+	if !s.ServerTime.IsZero() {
+		now = now.Add(s.ClockSkew)
+	}
+	fmt.Printf("user: %s <%s>\n", s.Name, s.Email)
+	fmt.Printf("access token expires: %s\n", formatExpiry(s.AccessTokenExpiry, now))
+	fmt.Printf("session expires: %s\n", formatExpiry(s.Expires, now))
+	if !s.ServerTime.IsZero() {
+		fmt.Printf("clock skew: %s\n", s.ClockSkew.Round(time.Millisecond))
+	}
+
+	switch {
+	case s.Expires.IsZero():
+		fmt.Println("status: ok")
+	case !s.Expires.After(now):
+		fmt.Println("status: expired")
+		return &ExitError{Code: ExitExpired, Err: fmt.Errorf("session expired at %s", s.Expires.Format(time.RFC3339))}
+	case s.Expires.Sub(now) < warn:
+		fmt.Println("status: expiring")
+		return &ExitError{Code: ExitExpiring, Err: fmt.Errorf("session expires in %s", s.Expires.Sub(now).Round(time.Second))}
+	default:
+		fmt.Println("status: ok")
+	}
+	return nil
+}
+
+func formatExpiry(t, now time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	d := t.Sub(now).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.RFC3339), -d)
+	}
+	return fmt.Sprintf("%s (in %s)", t.Local().Format(time.RFC3339), d)
+}
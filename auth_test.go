@@ -0,0 +1,141 @@
+package leonai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/leonai/pkg/leonardo"
+)
+
+func TestSessionStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		// expires is the session expiration from now, zero if unknown
+		expires  time.Duration
+		wantCode int
+	}{
+		{"ok", 10 * 24 * time.Hour, 0},
+		{"unknown expiration", 0, 0},
+		{"expiring", time.Hour, ExitExpiring},
+		{"expired", -time.Hour, ExitExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &leonardo.Session{
+				Name:              "name",
+				Email:             "name@example.com",
+				AccessTokenExpiry: time.Now().Add(time.Hour),
+			}
+			if tt.expires != 0 {
+				s.Expires = time.Now().Add(tt.expires)
+			}
+			err := sessionStatus(s, 24*time.Hour)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var exitErr *ExitError
+			if !errors.As(err, &exitErr) || exitErr.Code != tt.wantCode {
+				t.Fatalf("expected exit code %d, got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestSessionStatusClockSkew(t *testing.T) {
+	// The local clock is an hour behind the server, so a session that looks
+	// valid for 30 minutes locally has already expired.
+	s := &leonardo.Session{
+		Expires:    time.Now().Add(30 * time.Minute),
+		ServerTime: time.Now().Add(time.Hour),
+		ClockSkew:  time.Hour,
+	}
+	var exitErr *ExitError
+	if err := sessionStatus(s, time.Minute); !errors.As(err, &exitErr) || exitErr.Code != ExitExpired {
+		t.Fatalf("expected exit code %d, got %v", ExitExpired, err)
+	}
+}
+
+func TestAuthStatus(t *testing.T) {
+	t.Setenv(CookiePassphraseEnv, "")
+	tests := []struct {
+		name string
+		// expires is the session expiration from now, zero if not sent
+		expires  time.Duration
+		noToken  bool
+		wantCode int
+		wantErr  error
+	}{
+		{"ok", 10 * 24 * time.Hour, false, 0, nil},
+		{"unknown expiration", 0, false, 0, nil},
+		{"expiring", time.Hour, false, ExitExpiring, nil},
+		{"expired", -time.Hour, false, ExitExpired, nil},
+		{"no session", 0, true, ExitExpired, leonardo.ErrNoSession},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/auth/session" {
+					http.NotFound(w, r)
+					return
+				}
+				if tt.noToken {
+					http.SetCookie(w, &http.Cookie{Name: "session", Value: "rotated", Path: "/"})
+					fmt.Fprint(w, `{}`)
+					return
+				}
+				var expires string
+				if tt.expires != 0 {
+					expires = time.Now().Add(tt.expires).UTC().Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, `{"user":{"name":"name","email":"name@example.com"},"accessToken":"token","accessTokenExpiry":%d,"expires":%q,"serverTimestamp":%d}`,
+					time.Now().Add(time.Hour).Unix(), expires, time.Now().UnixMilli())
+			}))
+			defer srv.Close()
+
+			cookie := filepath.Join(t.TempDir(), "cookie.txt")
+			if err := os.WriteFile(cookie, []byte("session=abc"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err := AuthStatus(context.Background(), &Config{
+				Wait:   time.Millisecond,
+				Cookie: cookie,
+				AppURL: srv.URL,
+			}, 24*time.Hour)
+
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var exitErr *ExitError
+			if !errors.As(err, &exitErr) || exitErr.Code != tt.wantCode {
+				t.Fatalf("expected exit code %d, got %v", tt.wantCode, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.noToken {
+				// The rotated cookie is saved even without a session
+				b, err := os.ReadFile(cookie)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(b), "rotated") {
+					t.Errorf("expected rotated cookie to be saved, got %q", b)
+				}
+			}
+		})
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/igolaizola/leonai"
+	"github.com/igolaizola/leonai/pkg/journal"
+	"github.com/igolaizola/leonai/pkg/leonardo"
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
@@ -28,6 +33,11 @@ func main() {
 	// Launch command
 	cmd := newCommand()
 	if err := cmd.ParseAndRun(ctx, os.Args[1:]); err != nil {
+		var exitErr *leonai.ExitError
+		if errors.As(err, &exitErr) {
+			log.Println(err)
+			os.Exit(exitErr.Code)
+		}
 		log.Fatal(err)
 	}
 }
@@ -44,6 +54,12 @@ func newCommand() *ffcli.Command {
 		Subcommands: []*ffcli.Command{
 			newVersionCommand(),
 			newVideoCommand(),
+			newResumeCommand(),
+			newJobsCommand(),
+			newStatusCommand(),
+			newWaitCommand(),
+			newCookieCommand(),
+			newAuthCommand(),
 		},
 	}
 }
@@ -82,10 +98,8 @@ func newVideoCommand() *ffcli.Command {
 	_ = fs.String("config", "", "config file (optional)")
 
 	cfg := &leonai.Config{}
-	fs.StringVar(&cfg.Cookie, "cookie", "", "cookie file")
-	fs.StringVar(&cfg.Proxy, "proxy", "", "proxy")
-	fs.DurationVar(&cfg.Wait, "wait", 0, "wait time")
-	fs.BoolVar(&cfg.Debug, "debug", false, "debug mode")
+	addClientFlags(fs, cfg)
+	fs.StringVar(&cfg.Journal, "journal", journal.DefaultDir(), "directory where submitted jobs are saved")
 
 	var image string
 	fs.StringVar(&image, "image", "", "image to use")
@@ -93,6 +107,10 @@ func newVideoCommand() *ffcli.Command {
 	fs.IntVar(&motionStrength, "motion-strength", 5, "motion strength")
 	var output string
 	fs.StringVar(&output, "output", "", "output file")
+	fs.BoolVar(&cfg.Force, "force", false, "overwrite the output file if it exists")
+	fs.StringVar(&cfg.NSFW, "nsfw", leonai.NSFWAllow, "what to do with results flagged as nsfw: allow|skip|fail")
+	fs.BoolVar(&cfg.NoUploadCache, "no-upload-cache", false, "upload the image even if the same file was uploaded before")
+	fs.DurationVar(&cfg.DownloadTimeout, "download-timeout", leonai.DefaultDownloadTimeout, "time limit of each download attempt")
 
 	return &ffcli.Command{
 		Name:       cmd,
@@ -109,3 +127,263 @@ func newVideoCommand() *ffcli.Command {
 		},
 	}
 }
+
+// addClientFlags adds the flags used to connect to leonardo.
+func addClientFlags(fs *flag.FlagSet, cfg *leonai.Config) {
+	fs.StringVar(&cfg.Cookie, "cookie", "", "cookie file")
+	fs.StringVar(&cfg.CookieKeyFile, "cookie-key-file", "", fmt.Sprintf("key file to decrypt the cookie file, or use %s", leonai.CookiePassphraseEnv))
+	fs.StringVar(&cfg.CookieFromBrowser, "cookie-from-browser", "", "read cookies from a local browser profile: firefox|chromium|chrome|brave|edge[:profile]")
+	fs.StringVar(&cfg.APIKey, "api-key", "", "official leonardo api key, used instead of the cookie")
+	fs.StringVar(&cfg.Backend, "backend", "", "api backend: graphql|rest (default rest with api-key, graphql otherwise)")
+	fs.StringVar(&cfg.AppURL, "app-url", leonardo.DefaultAppURL, "web app base url")
+	fs.StringVar(&cfg.APIURL, "api-url", leonardo.DefaultAPIURL, "graphql api base url")
+	fs.StringVar(&cfg.RESTURL, "rest-url", leonardo.DefaultRESTURL, "official rest api base url")
+	fs.BoolVar(&cfg.Subscribe, "subscribe", false, "receive status updates over a graphql subscription instead of polling")
+	fs.BoolVar(&cfg.AutoRefresh, "auto-refresh", false, "renew the access token in the background and warn before the session cookie expires")
+	retry := leonardo.DefaultRetryPolicy()
+	fs.IntVar(&cfg.RetryAttempts, "retry-attempts", retry.MaxAttempts, "maximum attempts of each request")
+	fs.DurationVar(&cfg.RetryBaseDelay, "retry-base-delay", retry.BaseDelay, "wait before the first retry, doubled on each retry")
+	fs.DurationVar(&cfg.RetryMaxDelay, "retry-max-delay", retry.MaxDelay, "maximum wait between retries")
+	cfg.RetryJitter = retry.Jitter
+	fs.Func("retry-jitter", fmt.Sprintf("fraction of the retry wait that is randomized, negative to disable (default %v)", retry.Jitter), func(v string) error {
+		jitter, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		if jitter == 0 {
+			return errors.New("zero uses the default, use a negative value to disable jitter")
+		}
+		cfg.RetryJitter = jitter
+		return nil
+	})
+	fs.StringVar(&cfg.Proxy, "proxy", "", "proxy url: http|https|socks5://[user:pass@]host:port (default from HTTPS_PROXY/HTTP_PROXY/NO_PROXY)")
+	fs.StringVar(&cfg.CACert, "ca-cert", "", "PEM bundle with extra root certificates to trust")
+	fs.DurationVar(&cfg.Wait, "wait", 0, "wait time")
+	fs.BoolVar(&cfg.Debug, "debug", false, "debug mode")
+	fs.StringVar(&cfg.DebugDir, "debug-dir", "", "directory where failed requests are dumped with credentials redacted (disabled if empty)")
+}
+
+func newResumeCommand() *ffcli.Command {
+	cmd := "resume"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	cfg := &leonai.Config{}
+	addClientFlags(fs, cfg)
+	fs.StringVar(&cfg.Journal, "journal", journal.DefaultDir(), "directory where submitted jobs are saved")
+	fs.BoolVar(&cfg.Force, "force", false, "overwrite the output files if they exist")
+	fs.StringVar(&cfg.NSFW, "nsfw", leonai.NSFWAllow, "what to do with results flagged as nsfw: allow|skip|fail")
+	fs.DurationVar(&cfg.DownloadTimeout, "download-timeout", leonai.DefaultDownloadTimeout, "time limit of each download attempt")
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai %s [flags]", cmd),
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("LEONAI"),
+		},
+		ShortHelp: "finish the pending jobs and download their outputs",
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return leonai.Resume(ctx, cfg)
+		},
+	}
+}
+
+func newJobsCommand() *ffcli.Command {
+	cmd := "jobs"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	cfg := &leonai.Config{}
+	fs.StringVar(&cfg.Journal, "journal", journal.DefaultDir(), "directory where submitted jobs are saved")
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai %s [flags]", cmd),
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("LEONAI"),
+		},
+		ShortHelp: "list the submitted jobs",
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return leonai.Jobs(ctx, cfg)
+		},
+	}
+}
+
+func newStatusCommand() *ffcli.Command {
+	cmd := "status"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	cfg := &leonai.Config{}
+	addClientFlags(fs, cfg)
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai %s [flags] <id...>", cmd),
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("LEONAI"),
+		},
+		ShortHelp: "print the status of generations",
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return leonai.Status(ctx, cfg, args)
+		},
+	}
+}
+
+func newWaitCommand() *ffcli.Command {
+	cmd := "wait"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	cfg := &leonai.Config{}
+	addClientFlags(fs, cfg)
+	var outputDir string
+	fs.StringVar(&outputDir, "output-dir", "", "directory to download the videos to, named after the generation id")
+	fs.BoolVar(&cfg.Force, "force", false, "overwrite the output files if they exist")
+	fs.DurationVar(&cfg.DownloadTimeout, "download-timeout", leonai.DefaultDownloadTimeout, "time limit of each download attempt")
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai %s [flags] <id...>", cmd),
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("LEONAI"),
+		},
+		ShortHelp: "wait for generations and download their videos, fails if any generation failed",
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return leonai.Wait(ctx, cfg, args, outputDir)
+		},
+	}
+}
+
+func newCookieCommand() *ffcli.Command {
+	cmd := "cookie"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai %s <subcommand>", cmd),
+		ShortHelp:  "manage encrypted cookie files",
+		FlagSet:    fs,
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			newCookieSubcommand("encrypt", "encrypt a cookie file in place", leonai.EncryptCookie),
+			newCookieSubcommand("decrypt", "decrypt a cookie file in place", leonai.DecryptCookie),
+			newCookieRotateKeyCommand(),
+		},
+	}
+}
+
+func newCookieSubcommand(cmd, help string, fn func(context.Context, *leonai.Config) error) *ffcli.Command {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	cfg := &leonai.Config{}
+	fs.StringVar(&cfg.Cookie, "cookie", "", "cookie file")
+	fs.StringVar(&cfg.CookieKeyFile, "cookie-key-file", "", fmt.Sprintf("key file, or use %s", leonai.CookiePassphraseEnv))
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai cookie %s [flags]", cmd),
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("LEONAI"),
+		},
+		ShortHelp: help,
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return fn(ctx, cfg)
+		},
+	}
+}
+
+func newCookieRotateKeyCommand() *ffcli.Command {
+	cmd := "rotate-key"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	cfg := &leonai.Config{}
+	fs.StringVar(&cfg.Cookie, "cookie", "", "cookie file")
+	fs.StringVar(&cfg.CookieKeyFile, "cookie-key-file", "", fmt.Sprintf("current key file, or use %s", leonai.CookiePassphraseEnv))
+	var newKeyFile string
+	fs.StringVar(&newKeyFile, "new-cookie-key-file", "", fmt.Sprintf("new key file, or use %s", leonai.NewCookiePassphraseEnv))
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai cookie %s [flags]", cmd),
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("LEONAI"),
+		},
+		ShortHelp: "re-encrypt a cookie file with a new key",
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return leonai.RotateCookieKey(ctx, cfg, newKeyFile)
+		},
+	}
+}
+
+func newAuthCommand() *ffcli.Command {
+	cmd := "auth"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai %s <subcommand>", cmd),
+		ShortHelp:  "authentication commands",
+		FlagSet:    fs,
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			newAuthStatusCommand(),
+		},
+	}
+}
+
+func newAuthStatusCommand() *ffcli.Command {
+	cmd := "status"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	cfg := &leonai.Config{}
+	fs.StringVar(&cfg.Cookie, "cookie", "", "cookie file")
+	fs.StringVar(&cfg.CookieKeyFile, "cookie-key-file", "", fmt.Sprintf("key file to decrypt the cookie file, or use %s", leonai.CookiePassphraseEnv))
+	fs.StringVar(&cfg.CookieFromBrowser, "cookie-from-browser", "", "read cookies from a local browser profile: firefox|chromium|chrome|brave|edge[:profile]")
+	fs.StringVar(&cfg.AppURL, "app-url", leonardo.DefaultAppURL, "web app base url")
+	fs.StringVar(&cfg.Proxy, "proxy", "", "proxy url: http|https|socks5://[user:pass@]host:port (default from HTTPS_PROXY/HTTP_PROXY/NO_PROXY)")
+	fs.StringVar(&cfg.CACert, "ca-cert", "", "PEM bundle with extra root certificates to trust")
+	fs.BoolVar(&cfg.Debug, "debug", false, "debug mode")
+	var warn time.Duration
+	fs.DurationVar(&warn, "warn", 72*time.Hour, "exit with code 2 if the session expires within this duration")
+
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("leonai auth %s [flags]", cmd),
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("LEONAI"),
+		},
+		ShortHelp: "show the session status, exits with 2 if it expires soon and 3 if it expired",
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return leonai.AuthStatus(ctx, cfg, warn)
+		},
+	}
+}
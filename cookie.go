@@ -0,0 +1,133 @@
+package leonai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/igolaizola/leonai/pkg/crypt"
+	"github.com/igolaizola/leonai/pkg/leonardo"
+)
+
+// Environment variables used to obtain the passphrase of encrypted cookie
+// files when no key file is provided.
+const (
+	CookiePassphraseEnv    = "LEONAI_COOKIE_PASSPHRASE"
+	NewCookiePassphraseEnv = "LEONAI_NEW_COOKIE_PASSPHRASE"
+)
+
+// cookieSecret returns the secret used to encrypt the cookie file, read from
+// the key file or the environment variable. It returns nil if none is set.
+func cookieSecret(keyFile, env string) ([]byte, error) {
+	if keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read key file: %w", err)
+		}
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			return nil, fmt.Errorf("key file %s is empty", keyFile)
+		}
+		return b, nil
+	}
+	if v := os.Getenv(env); v != "" {
+		return []byte(v), nil
+	}
+	return nil, nil
+}
+
+func requiredCookieSecret(keyFile, env string) ([]byte, error) {
+	secret, err := cookieSecret(keyFile, env)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("a key file or the %s environment variable is required", env)
+	}
+	return secret, nil
+}
+
+// EncryptCookie encrypts a plain cookie file in place.
+func EncryptCookie(ctx context.Context, cfg *Config) error {
+	if cfg.Cookie == "" {
+		return errors.New("cookie file is required")
+	}
+	secret, err := requiredCookieSecret(cfg.CookieKeyFile, CookiePassphraseEnv)
+	if err != nil {
+		return err
+	}
+	cookie, err := leonardo.NewCookieStore(cfg.Cookie).GetCookie(ctx)
+	if err != nil {
+		return err
+	}
+	if crypt.IsEncrypted([]byte(cookie)) {
+		return fmt.Errorf("cookie file %s is already encrypted", cfg.Cookie)
+	}
+	if err := leonardo.NewEncryptedCookieStore(cfg.Cookie, secret).SetCookie(ctx, cookie); err != nil {
+		return err
+	}
+	removeToken(ctx, cfg.Cookie)
+	log.Printf("cookie file %s encrypted\n", cfg.Cookie)
+	return nil
+}
+
+// DecryptCookie decrypts an encrypted cookie file in place.
+func DecryptCookie(ctx context.Context, cfg *Config) error {
+	if cfg.Cookie == "" {
+		return errors.New("cookie file is required")
+	}
+	secret, err := requiredCookieSecret(cfg.CookieKeyFile, CookiePassphraseEnv)
+	if err != nil {
+		return err
+	}
+	cookie, err := leonardo.NewEncryptedCookieStore(cfg.Cookie, secret).GetCookie(ctx)
+	if err != nil {
+		return err
+	}
+	if err := leonardo.NewCookieStore(cfg.Cookie).SetCookie(ctx, cookie); err != nil {
+		return err
+	}
+	removeToken(ctx, cfg.Cookie)
+	log.Printf("cookie file %s decrypted\n", cfg.Cookie)
+	return nil
+}
+
+// RotateCookieKey re-encrypts an encrypted cookie file with a new key.
+func RotateCookieKey(ctx context.Context, cfg *Config, newKeyFile string) error {
+	if cfg.Cookie == "" {
+		return errors.New("cookie file is required")
+	}
+	secret, err := requiredCookieSecret(cfg.CookieKeyFile, CookiePassphraseEnv)
+	if err != nil {
+		return err
+	}
+	newSecret, err := requiredCookieSecret(newKeyFile, NewCookiePassphraseEnv)
+	if err != nil {
+		return fmt.Errorf("new key: %w", err)
+	}
+	cookie, err := leonardo.NewEncryptedCookieStore(cfg.Cookie, secret).GetCookie(ctx)
+	if err != nil {
+		return err
+	}
+	if err := leonardo.NewEncryptedCookieStore(cfg.Cookie, newSecret).SetCookie(ctx, cookie); err != nil {
+		return err
+	}
+	removeToken(ctx, cfg.Cookie)
+	log.Printf("cookie file %s encrypted with the new key\n", cfg.Cookie)
+	return nil
+}
+
+// removeToken removes the access token cached next to the cookie file, it
+// will be cached again with the new protection on the next run.
+func removeToken(ctx context.Context, cookie string) {
+	ts, ok := leonardo.NewCookieStore(cookie).(leonardo.TokenStore)
+	if !ok {
+		return
+	}
+	if err := ts.SetToken(ctx, nil); err != nil {
+		log.Println(err)
+	}
+}
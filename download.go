@@ -0,0 +1,185 @@
+package leonai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultDownloadTimeout is the default time limit of each download attempt.
+const DefaultDownloadTimeout = 10 * time.Minute
+
+// checkOutput returns an error if the output file exists and force isn't set.
+func checkOutput(output string, force bool) error {
+	if output == "" || force {
+		return nil
+	}
+	if _, err := os.Stat(output); err == nil {
+		return fmt.Errorf("output file %s already exists, use --force to overwrite it", output)
+	}
+	return nil
+}
+
+// download downloads the url to output. The file is written to a temporary
+// file next to output that is renamed into place when it is complete, so an
+// interrupted download never leaves a truncated output. Interrupted downloads
+// are resumed using range requests, with the ETag to check that the remote
+// file didn't change.
+func download(ctx context.Context, client *http.Client, u, output string, force bool) error {
+	if err := checkOutput(output, force); err != nil {
+		return err
+	}
+	part := output + ".part"
+	maxAttempts := 3
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = downloadAttempt(ctx, client, u, part)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		// Only retry interrupted transfers, the partial file is resumed
+		if !retryableDownload(err) {
+			return err
+		}
+		if attempt < maxAttempts {
+			log.Printf("download interrupted, resuming: %v\n", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(part, output); err != nil {
+		return fmt.Errorf("couldn't rename %s to %s: %w", part, output, err)
+	}
+	_ = os.Remove(part + ".etag")
+	return nil
+}
+
+// retryableDownload reports whether a download error is a timeout or an
+// interrupted connection. Other errors, like invalid URLs or TLS failures,
+// wouldn't succeed on a retry.
+func retryableDownload(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func downloadAttempt(ctx context.Context, client *http.Client, u, part string) error {
+	// Check if there is a partial download to resume
+	var offset int64
+	etag := readETag(part)
+	if info, err := os.Stat(part); err == nil && etag != "" {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't create request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't download video: %w", err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Start from the beginning, the file is new or it changed
+		offset = 0
+		flags |= os.O_TRUNC
+		if err := writeETag(part, resp.Header.Get("ETag")); err != nil {
+			return err
+		}
+	case http.StatusPartialContent:
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			return fmt.Errorf("couldn't resume download: got range starting at %d, want %d", start, offset)
+		}
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is invalid, start again on the next attempt
+		_ = os.Remove(part)
+		_ = os.Remove(part + ".etag")
+		return fmt.Errorf("couldn't resume download: %w", io.ErrUnexpectedEOF)
+	default:
+		return fmt.Errorf("couldn't download video: unexpected status %s", resp.Status)
+	}
+
+	f, err := os.OpenFile(part, flags, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer f.Close()
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return fmt.Errorf("couldn't write to temp file: %w", err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("couldn't download video: got %d bytes, want %d: %w", n, resp.ContentLength, io.ErrUnexpectedEOF)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("couldn't sync temp file: %w", err)
+	}
+	return f.Close()
+}
+
+// contentRangeStart parses the first byte of a "bytes start-end/size" header.
+func contentRangeStart(v string) (int64, error) {
+	rng, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid content range %q", v)
+	}
+	start, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid content range %q", v)
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid content range %q: %w", v, err)
+	}
+	return n, nil
+}
+
+// readETag returns the ETag of the partial download.
+func readETag(part string) string {
+	b, err := os.ReadFile(part + ".etag")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// writeETag saves the ETag of the partial download, only strong ETags can be
+// used to resume.
+func writeETag(part, etag string) error {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		_ = os.Remove(part + ".etag")
+		return nil
+	}
+	if err := os.WriteFile(part+".etag", []byte(etag), 0600); err != nil {
+		return fmt.Errorf("couldn't write etag: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package leonai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDownloadStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>forbidden</html>", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "car.mp4")
+	if err := download(context.Background(), srv.Client(), srv.URL, output, false); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output file shouldn't exist: %v", err)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	data := bytes.Repeat([]byte("video"), 1000)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "car.mp4", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	// Simulate an interrupted download
+	output := filepath.Join(t.TempDir(), "car.mp4")
+	if err := os.WriteFile(output+".part", data[:1234], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(output+".part.etag", []byte(`"v1"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := download(context.Background(), srv.Client(), srv.URL, output, false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, want %d", len(got), len(data))
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=1234-" {
+		t.Errorf("ranges = %v, want [bytes=1234-]", ranges)
+	}
+	if _, err := os.Stat(output + ".part"); !os.IsNotExist(err) {
+		t.Errorf("partial file shouldn't exist: %v", err)
+	}
+
+	// The file changed, so the partial download is discarded
+	if err := os.WriteFile(output+".part", []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(output+".part.etag", []byte(`"v0"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := download(context.Background(), srv.Client(), srv.URL, output, true); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestDownloadForce(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "car.mp4")
+	if err := os.WriteFile(output, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := download(context.Background(), http.DefaultClient, "http://127.0.0.1:0", output, false)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected overwrite error, got %v", err)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDownloadRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"timeout", timeoutError{}, 3},
+		{"connection reset", syscall.ECONNRESET, 3},
+		{"certificate", errors.New("x509: certificate signed by unknown authority"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+			client := &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+				attempts++
+				return nil, tt.err
+			})}
+			output := filepath.Join(t.TempDir(), "car.mp4")
+			if err := download(context.Background(), client, "http://leonardo.test/car.mp4", output, false); err == nil {
+				t.Fatal("expected error")
+			}
+			if attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestDownloadPermissions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "10")
+		// The connection is closed before the whole body is sent
+		_, _ = w.Write([]byte("video"))
+	}))
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "car.mp4")
+	if err := download(context.Background(), srv.Client(), srv.URL, output, false); err == nil {
+		t.Fatal("expected error")
+	}
+	for _, path := range []string{output + ".part", output + ".part.etag"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s permissions = %o, want 600", filepath.Base(path), perm)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package leonai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/igolaizola/leonai/pkg/journal"
+	"github.com/igolaizola/leonai/pkg/leonardo"
+)
+
+// finishJob waits for the generation of a job, downloads its output and
+// updates the journal.
+func finishJob(ctx context.Context, cfg *Config, client *leonardo.Client, httpClient *http.Client, jobs *journal.Journal, job *journal.Job) error {
+	gen, err := client.WaitGeneration(ctx, job.ID)
+	var video *leonardo.GeneratedImage
+	if err == nil {
+		video, err = gen.Video()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("job %s is still pending, run leonai resume to finish it\n", job.ID)
+			return err
+		}
+		var errFailed *leonardo.ErrGenerationFailed
+		if errors.As(err, &errFailed) || errors.Is(err, leonardo.ErrModerated) {
+			job.State = journal.StateFailed
+			job.Error = err.Error()
+			job.NSFW = errors.Is(err, leonardo.ErrModerated)
+			saveJob(jobs, job)
+		}
+		return fmt.Errorf("couldn't create motion: %w", err)
+	}
+	log.Println("id:", video.ID)
+	log.Println("url:", video.MotionMP4URL)
+	job.VideoURL = video.MotionMP4URL
+	if gen.Flagged() {
+		log.Println("nsfw: true")
+		job.NSFW = true
+		switch cfg.NSFW {
+		case NSFWSkip:
+			log.Printf("skipping job %s, its result is flagged as nsfw\n", job.ID)
+			job.State = journal.StateSkipped
+			job.Error = ""
+			saveJob(jobs, job)
+			return nil
+		case NSFWFail:
+			err := &leonardo.ModerationError{ID: job.ID, Reason: gen.Reason, NSFW: true}
+			job.State = journal.StateFailed
+			job.Error = err.Error()
+			saveJob(jobs, job)
+			return err
+		}
+	}
+	if job.Output != "" && downloaded(cfg, job) {
+		// The process stopped after downloading the output but before
+		// updating the journal
+		log.Printf("job %s output %s was already downloaded\n", job.ID, job.Output)
+	} else if job.Output != "" {
+		if err := download(ctx, downloadClient(cfg, httpClient), video.MotionMP4URL, job.Output, cfg.Force); err != nil {
+			saveJob(jobs, job)
+			return fmt.Errorf("couldn't download video: %w", err)
+		}
+	}
+	job.State = journal.StateDone
+	job.Error = ""
+	saveJob(jobs, job)
+	return nil
+}
+
+// downloaded reports whether the output of the job was written after the
+// job was created, so it must be its own download. Files that existed before
+// are still only overwritten with force.
+func downloaded(cfg *Config, job *journal.Job) bool {
+	if cfg.Force {
+		return false
+	}
+	info, err := os.Stat(job.Output)
+	if err != nil {
+		return false
+	}
+	return info.ModTime().After(job.CreatedAt)
+}
+
+func saveJob(jobs *journal.Journal, job *journal.Job) {
+	if err := jobs.Save(job); err != nil {
+		log.Printf("couldn't save job %s: %v\n", job.ID, err)
+	}
+}
+
+// Resume finishes the pending jobs of the journal.
+func Resume(ctx context.Context, cfg *Config) error {
+	if err := checkNSFW(cfg.NSFW); err != nil {
+		return err
+	}
+	jobs, err := journal.Open(cfg.Journal)
+	if err != nil {
+		return err
+	}
+	all, err := jobs.List()
+	if err != nil {
+		return err
+	}
+	var pending []*journal.Job
+	for _, job := range all {
+		if job.State == journal.StatePending {
+			pending = append(pending, job)
+		}
+	}
+	if len(pending) == 0 {
+		log.Println("no pending jobs")
+		return nil
+	}
+
+	client, httpClient, err := newClient(cfg, false)
+	if err != nil {
+		return err
+	}
+	if err := client.Start(ctx); err != nil {
+		return fmt.Errorf("couldn't start leonardo client: %w", err)
+	}
+	defer func() {
+		if err := client.Stop(ctx); err != nil {
+			log.Printf("couldn't stop leonardo client: %v\n", err)
+		}
+	}()
+	// Jobs are finished concurrently, their statuses are polled together
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failed int
+	for _, job := range pending {
+		job := job
+		log.Printf("resuming job %s\n", job.ID)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := finishJob(ctx, cfg, client, httpClient, jobs, job); err != nil {
+				log.Printf("job %s: %v\n", job.ID, err)
+				lock.Lock()
+				failed++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs couldn't be finished", failed, len(pending))
+	}
+	return nil
+}
+
+// Jobs prints the jobs of the journal.
+func Jobs(ctx context.Context, cfg *Config) error {
+	jobs, err := journal.Open(cfg.Journal)
+	if err != nil {
+		return err
+	}
+	all, err := jobs.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tCREATED\tOUTPUT\tERROR")
+	for _, job := range all {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.ID, job.State, job.CreatedAt.Local().Format(time.DateTime), job.Output, job.Error)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,171 @@
+package leonai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/leonai/pkg/journal"
+)
+
+// restStandIn serves the REST API endpoints used to resume jobs. statuses
+// maps generation ids to their status.
+func restStandIn(t *testing.T, statuses map[string]string) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/video.mp4":
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(w, "video")
+		case r.Header.Get("Authorization") != "Bearer key":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.URL.Path == "/api/rest/v1/me":
+			fmt.Fprint(w, `{"user_details":[{"user":{"id":"user-1"}}]}`)
+		case strings.HasPrefix(r.URL.Path, "/api/rest/v1/generations/"):
+			id := strings.TrimPrefix(r.URL.Path, "/api/rest/v1/generations/")
+			status, ok := statuses[id]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			var images string
+			if status == "COMPLETE" {
+				images = fmt.Sprintf(`{"id":"img-1","url":"%s/image.png","motionMP4URL":"%s/video.mp4"}`, srv.URL, srv.URL)
+			}
+			fmt.Fprintf(w, `{"generations_by_pk":{"id":%q,"status":%q,"createdAt":"2024-01-01T00:00:00Z","generated_images":[%s]}}`, id, status, images)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	return srv
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		timeout time.Duration
+		want    string
+	}{
+		{"complete", "COMPLETE", 10 * time.Second, journal.StateDone},
+		{"failed", "FAILED", 10 * time.Second, journal.StateFailed},
+		{"cancelled", "PENDING", 100 * time.Millisecond, journal.StatePending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := restStandIn(t, map[string]string{"gen-1": tt.status})
+			defer srv.Close()
+
+			dir := t.TempDir()
+			jobs, err := journal.Open(filepath.Join(dir, "jobs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := filepath.Join(dir, "car.mp4")
+			if err := jobs.Save(&journal.Job{
+				ID:     "gen-1",
+				Output: output,
+				State:  journal.StatePending,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			err = Resume(ctx, &Config{
+				Wait:    time.Millisecond,
+				APIKey:  "key",
+				RESTURL: srv.URL + "/api/rest/v1/",
+				Journal: jobs.Dir(),
+			})
+			if (err == nil) != (tt.want == journal.StateDone) {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			job, err := jobs.Get("gen-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.State != tt.want {
+				t.Errorf("state = %q, want %q", job.State, tt.want)
+			}
+			got, err := os.ReadFile(output)
+			if tt.want == journal.StateDone {
+				if err != nil || string(got) != "video" {
+					t.Errorf("output = %q %v, want video", got, err)
+				}
+			} else if !os.IsNotExist(err) {
+				t.Errorf("output shouldn't exist: %v", err)
+			}
+		})
+	}
+}
+
+func TestResumeDownloaded(t *testing.T) {
+	tests := []struct {
+		name string
+		// modified is when the output was written relative to the job
+		modified time.Duration
+		want     string
+	}{
+		// The process stopped between the download and the journal update
+		{"after the job", time.Minute, journal.StateDone},
+		{"before the job", -time.Hour, journal.StatePending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := restStandIn(t, map[string]string{"gen-1": "COMPLETE"})
+			defer srv.Close()
+
+			dir := t.TempDir()
+			jobs, err := journal.Open(filepath.Join(dir, "jobs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := filepath.Join(dir, "car.mp4")
+			job := &journal.Job{
+				ID:     "gen-1",
+				Output: output,
+				State:  journal.StatePending,
+			}
+			if err := jobs.Save(job); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(output, []byte("existing"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			modified := job.CreatedAt.Add(tt.modified)
+			if err := os.Chtimes(output, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+
+			err = Resume(context.Background(), &Config{
+				Wait:    time.Millisecond,
+				APIKey:  "key",
+				RESTURL: srv.URL + "/api/rest/v1/",
+				Journal: jobs.Dir(),
+			})
+			if (err == nil) != (tt.want == journal.StateDone) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			got, err := jobs.Get("gen-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.State != tt.want {
+				t.Errorf("state = %q, want %q", got.State, tt.want)
+			}
+			// The existing output is never overwritten without force
+			if b, err := os.ReadFile(output); err != nil || string(b) != "existing" {
+				t.Errorf("output = %q %v, want existing", b, err)
+			}
+		})
+	}
+}
@@ -2,44 +2,101 @@ package leonai
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/igolaizola/leonai/pkg/browser"
+	"github.com/igolaizola/leonai/pkg/journal"
 	"github.com/igolaizola/leonai/pkg/leonardo"
 )
 
 type Config struct {
-	Proxy  string
-	Wait   time.Duration
-	Debug  bool
-	Cookie string
+	Proxy             string
+	CACert            string
+	Wait              time.Duration
+	Debug             bool
+	DebugDir          string
+	Cookie            string
+	CookieFromBrowser string
+	CookieKeyFile     string
+	APIKey            string
+	Backend           string
+	AppURL            string
+	APIURL            string
+	RESTURL           string
+	// Subscribe waits for generations with a GraphQL subscription.
+	Subscribe bool
+	// AutoRefresh renews the access token in the background and warns
+	// before the session cookie expires.
+	AutoRefresh bool
+	// Retry policy, zero values use the defaults.
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	RetryJitter    float64
+	// DownloadTimeout is the time limit of each download attempt.
+	DownloadTimeout time.Duration
+	// Force overwrites the output file if it exists.
+	Force bool
+	// NSFW is what to do with results flagged as NSFW: NSFWAllow, NSFWSkip
+	// or NSFWFail. Defaults to NSFWAllow.
+	NSFW string
+	// Journal is the directory where submitted jobs are saved.
+	Journal string
+	// NoUploadCache uploads the images even if they were uploaded before.
+	NoUploadCache bool
+}
+
+// NSFW policies.
+const (
+	// NSFWAllow downloads results flagged as NSFW.
+	NSFWAllow = "allow"
+	// NSFWSkip doesn't download results flagged as NSFW.
+	NSFWSkip = "skip"
+	// NSFWFail fails on results flagged as NSFW.
+	NSFWFail = "fail"
+)
+
+// checkNSFW validates the NSFW policy.
+func checkNSFW(policy string) error {
+	switch policy {
+	case "", NSFWAllow, NSFWSkip, NSFWFail:
+		return nil
+	default:
+		return fmt.Errorf("invalid nsfw policy %q, use %s, %s or %s", policy, NSFWAllow, NSFWSkip, NSFWFail)
+	}
 }
 
 // Run runs the leonai process.
 func GenerateVideo(ctx context.Context, cfg *Config, image string, motionStrength int, output string) error {
-	httpClient := &http.Client{
-		Timeout: 2 * time.Minute,
+	if err := checkNSFW(cfg.NSFW); err != nil {
+		return err
 	}
-	if cfg.Proxy != "" {
-		u, err := url.Parse(cfg.Proxy)
+	// Fail before generating if the output can't be written
+	if err := checkOutput(output, cfg.Force); err != nil {
+		return err
+	}
+	if output != "" {
+		abs, err := filepath.Abs(output)
 		if err != nil {
-			return fmt.Errorf("invalid proxy URL: %w", err)
-		}
-		httpClient.Transport = &http.Transport{
-			Proxy: http.ProxyURL(u),
+			return fmt.Errorf("couldn't get output path: %w", err)
 		}
+		output = abs
+	}
+	jobs, err := journal.Open(cfg.Journal)
+	if err != nil {
+		return err
+	}
+	client, httpClient, err := newClient(cfg, true)
+	if err != nil {
+		return err
 	}
-	client := leonardo.New(&leonardo.Config{
-		Wait:        cfg.Wait,
-		Debug:       cfg.Debug,
-		Client:      httpClient,
-		CookieStore: leonardo.NewCookieStore(cfg.Cookie),
-	})
 	if err := client.Start(ctx); err != nil {
 		return fmt.Errorf("couldn't start leonardo client: %w", err)
 	}
@@ -52,40 +109,134 @@ func GenerateVideo(ctx context.Context, cfg *Config, image string, motionStrengt
 	if err != nil {
 		return fmt.Errorf("couldn't upload image: %w", err)
 	}
-	id, u, err := client.CreateMotion(ctx, imageID, motionStrength)
+	generationID, err := client.SubmitMotion(ctx, imageID, motionStrength)
 	if err != nil {
 		return fmt.Errorf("couldn't create motion: %w", err)
 	}
-	log.Println("id:", id)
-	log.Println("url:", u)
-	if output != "" {
-		if err := download(ctx, httpClient, u, output); err != nil {
-			return fmt.Errorf("couldn't download video: %w", err)
-		}
+
+	// Save the job before waiting, so it can be resumed if interrupted
+	job := &journal.Job{
+		ID:             generationID,
+		Image:          image,
+		ImageID:        imageID,
+		MotionStrength: motionStrength,
+		Output:         output,
+		State:          journal.StatePending,
+	}
+	if err := jobs.Save(job); err != nil {
+		return fmt.Errorf("couldn't save job, use leonai wait %s to get it: %w", generationID, err)
 	}
-	return nil
+	return finishJob(ctx, cfg, client, httpClient, jobs, job)
 }
 
-func download(ctx context.Context, client *http.Client, url, output string) error {
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// newClient creates a leonardo client and the http client it uses.
+// The upload cache is only used if uploads is set.
+func newClient(cfg *Config, uploads bool) (*leonardo.Client, *http.Client, error) {
+	transport, err := newTransport(cfg.Proxy, cfg.CACert)
 	if err != nil {
-		return fmt.Errorf("couldn't create request: %w", err)
+		return nil, nil, err
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("couldn't download video: %w", err)
+	httpClient := &http.Client{
+		Timeout:   2 * time.Minute,
+		Transport: transport,
+	}
+	leonardoCfg := &leonardo.Config{
+		Wait:          cfg.Wait,
+		Debug:         cfg.Debug,
+		DebugDir:      cfg.DebugDir,
+		Client:        httpClient,
+		Backend:       cfg.Backend,
+		AppURL:        cfg.AppURL,
+		APIURL:        cfg.APIURL,
+		RESTURL:       cfg.RESTURL,
+		Subscriptions: cfg.Subscribe,
+		AutoRefresh:   cfg.AutoRefresh,
+		OnEvent:       logEvents(),
+		Retry: &leonardo.RetryPolicy{
+			MaxAttempts: cfg.RetryAttempts,
+			BaseDelay:   cfg.RetryBaseDelay,
+			MaxDelay:    cfg.RetryMaxDelay,
+			Jitter:      cfg.RetryJitter,
+		},
 	}
-	defer resp.Body.Close()
+	if uploads && !cfg.NoUploadCache {
+		// Images can still be uploaded without the cache
+		cache, err := newUploadCache()
+		if err != nil {
+			log.Printf("upload cache disabled: %v\n", err)
+		} else {
+			leonardoCfg.UploadCache = cache
+		}
+	}
+	if cfg.APIKey != "" {
+		if cfg.Cookie != "" || cfg.CookieFromBrowser != "" {
+			return nil, nil, errors.New("api key and cookie can't be used together")
+		}
+		leonardoCfg.Authenticator = leonardo.NewAPIKeyAuthenticator(cfg.APIKey)
+	} else {
+		cookieStore, err := newCookieStore(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		leonardoCfg.CookieStore = cookieStore
+	}
+	return leonardo.New(leonardoCfg), httpClient, nil
+}
 
-	// Write video to output
-	f, err := os.Create(output)
+func newCookieStore(cfg *Config) (leonardo.CookieStore, error) {
+	switch {
+	case cfg.CookieFromBrowser != "" && cfg.Cookie != "":
+		return nil, errors.New("cookie and cookie-from-browser can't be used together")
+	case cfg.CookieFromBrowser != "":
+		appURL := cfg.AppURL
+		if appURL == "" {
+			appURL = leonardo.DefaultAppURL
+		}
+		u, err := url.Parse(appURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid app URL: %w", err)
+		}
+		store, err := browser.NewCookieStore(cfg.CookieFromBrowser, u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create browser cookie store: %w", err)
+		}
+		return store, nil
+	case cfg.Cookie != "":
+		secret, err := cookieSecret(cfg.CookieKeyFile, CookiePassphraseEnv)
+		if err != nil {
+			return nil, err
+		}
+		if secret != nil {
+			return leonardo.NewEncryptedCookieStore(cfg.Cookie, secret), nil
+		}
+		return leonardo.NewCookieStore(cfg.Cookie), nil
+	default:
+		return nil, errors.New("cookie, cookie-from-browser or api-key is required")
+	}
+}
+
+// newUploadCache returns the upload cache stored in the user cache directory.
+func newUploadCache() (leonardo.UploadCache, error) {
+	dir, err := os.UserCacheDir()
 	if err != nil {
-		return fmt.Errorf("couldn't create temp file: %w", err)
+		return nil, fmt.Errorf("couldn't get cache directory: %w", err)
+	}
+	dir = filepath.Join(dir, "leonai")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("couldn't create cache directory: %w", err)
+	}
+	return leonardo.NewUploadCache(filepath.Join(dir, "uploads.json")), nil
+}
+
+// downloadClient returns a client that shares the transport of the API client
+// but uses the download timeout.
+func downloadClient(cfg *Config, httpClient *http.Client) *http.Client {
+	timeout := cfg.DownloadTimeout
+	if timeout == 0 {
+		timeout = DefaultDownloadTimeout
 	}
-	defer f.Close()
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		return fmt.Errorf("couldn't write to temp file: %w", err)
+	return &http.Client{
+		Transport: httpClient.Transport,
+		Timeout:   timeout,
 	}
-	return nil
 }
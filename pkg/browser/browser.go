@@ -0,0 +1,188 @@
+package browser
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// CookieStore reads cookies from a local browser profile.
+// It can be used as a leonardo.CookieStore.
+type CookieStore struct {
+	browser string
+	profile string
+	host    string
+}
+
+// NewCookieStore creates a cookie store from a browser spec with the format
+// `browser[:profile]`, e.g. `firefox`, `firefox:default-release` or
+// `chromium:/path/to/profile`. Only cookies that apply to host are returned.
+func NewCookieStore(spec, host string) (*CookieStore, error) {
+	name, profile, _ := strings.Cut(spec, ":")
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "firefox":
+	case "chromium", "chrome", "brave", "edge":
+	default:
+		return nil, fmt.Errorf("browser: unsupported browser: %q", name)
+	}
+	return &CookieStore{
+		browser: name,
+		profile: profile,
+		host:    host,
+	}, nil
+}
+
+// GetCookie returns the cookies of the host as a cookie header value.
+func (s *CookieStore) GetCookie(ctx context.Context) (string, error) {
+	var db string
+	var read func(context.Context, string, string, time.Time) ([]cookie, error)
+	switch s.browser {
+	case "firefox":
+		dir, err := firefoxProfile(firefoxRoot(), s.profile)
+		if err != nil {
+			return "", err
+		}
+		db = filepath.Join(dir, "cookies.sqlite")
+		read = readFirefox
+	default:
+		dir, err := chromiumProfile(chromiumRoot(s.browser), s.profile)
+		if err != nil {
+			return "", err
+		}
+		db, err = chromiumDB(dir)
+		if err != nil {
+			return "", err
+		}
+		read = readChromium
+	}
+	cookies, err := read(ctx, db, s.host, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if len(cookies) == 0 {
+		return "", fmt.Errorf("browser: no cookies found for %s in %s", s.host, db)
+	}
+	return header(cookies), nil
+}
+
+// SetCookie does nothing, the browser owns its cookies.
+func (s *CookieStore) SetCookie(ctx context.Context, cookie string) error {
+	return nil
+}
+
+type cookie struct {
+	name  string
+	value string
+	host  string
+}
+
+// header joins cookies in the cookie header format. If a cookie name is set
+// for several domains, the most specific one wins.
+func header(cookies []cookie) string {
+	var names []string
+	byName := map[string]cookie{}
+	for _, c := range cookies {
+		prev, ok := byName[c.name]
+		if !ok {
+			names = append(names, c.name)
+		}
+		if !ok || len(strings.TrimPrefix(c.host, ".")) > len(strings.TrimPrefix(prev.host, ".")) {
+			byName[c.name] = c
+		}
+	}
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, byName[name].value))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// hostCandidates returns the cookie domains that apply to the host: the host
+// itself for host-only cookies and every dotted parent domain.
+func hostCandidates(host string) []string {
+	host = strings.ToLower(host)
+	candidates := []string{host}
+	parts := strings.Split(host, ".")
+	for i := 0; i < len(parts)-1; i++ {
+		candidates = append(candidates, "."+strings.Join(parts[i:], "."))
+	}
+	return candidates
+}
+
+// openCopy copies the database, and its write-ahead log if present, to a
+// temporary directory and opens it. Browsers keep their databases locked
+// while running.
+func openCopy(path string) (*sql.DB, func(), error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, fmt.Errorf("browser: couldn't stat cookie database: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "leonai-cookies-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("browser: couldn't create temp dir: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	dst := filepath.Join(dir, "cookies.db")
+	for _, suffix := range []string{"", "-wal"} {
+		if err := copyFile(path+suffix, dst+suffix); err != nil {
+			if suffix != "" && os.IsNotExist(err) {
+				continue
+			}
+			cleanup()
+			return nil, nil, fmt.Errorf("browser: couldn't copy cookie database: %w", err)
+		}
+	}
+	db, err := sql.Open("sqlite", dst)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("browser: couldn't open cookie database: %w", err)
+	}
+	return db, func() {
+		_ = db.Close()
+		cleanup()
+	}, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func toArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home
+}
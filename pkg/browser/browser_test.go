@@ -0,0 +1,96 @@
+package browser
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFirefoxProfile(t *testing.T) {
+	root := filepath.Join("testdata", "firefox")
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{profile: "", want: "abcd1234.default-release"},
+		{profile: "default-release", want: "abcd1234.default-release"},
+		{profile: "work", want: "wxyz5678.work"},
+		{profile: "wxyz5678.work", want: "wxyz5678.work"},
+	}
+	for _, tt := range tests {
+		got, err := firefoxProfile(root, tt.profile)
+		if err != nil {
+			t.Fatalf("profile %q: %v", tt.profile, err)
+		}
+		if want := filepath.Join(root, tt.want); got != want {
+			t.Errorf("profile %q: got %s, want %s", tt.profile, got, want)
+		}
+	}
+	if _, err := firefoxProfile(root, "missing"); err == nil {
+		t.Error("expected error for missing profile")
+	}
+}
+
+func TestReadFirefox(t *testing.T) {
+	db := filepath.Join("testdata", "firefox", "abcd1234.default-release", "cookies.sqlite")
+	cookies, err := readFirefox(context.Background(), db, "app.leonardo.ai", testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := header(cookies)
+	want := "__Secure-next-auth.session-token=firefox-session; __Host-next-auth.csrf-token=firefox-csrf; _ga=firefox-ga"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadChromium(t *testing.T) {
+	root := filepath.Join("testdata", "chromium")
+	dir, err := chromiumProfile(root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := chromiumDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies, err := readChromium(context.Background(), db, "app.leonardo.ai", testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := header(cookies)
+	want := "__Secure-next-auth.session-token=chromium-session; _ga=chromium-ga"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadChromiumEncrypted(t *testing.T) {
+	dir, err := chromiumProfile(filepath.Join("testdata", "chromium"), "Profile 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := chromiumDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readChromium(context.Background(), db, "app.leonardo.ai", testNow)
+	if err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("expected encrypted error, got %v", err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	got := header([]cookie{
+		{name: "a", value: "parent", host: ".leonardo.ai"},
+		{name: "b", value: "b", host: "app.leonardo.ai"},
+		{name: "a", value: "host", host: "app.leonardo.ai"},
+	})
+	if want := "a=host; b=b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// chromiumRoot returns the user data directory of a chromium based browser.
+func chromiumRoot(browser string) string {
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		config = filepath.Join(homeDir(), ".config")
+	}
+	switch browser {
+	case "chrome":
+		return filepath.Join(config, "google-chrome")
+	case "brave":
+		return filepath.Join(config, "BraveSoftware", "Brave-Browser")
+	case "edge":
+		return filepath.Join(config, "microsoft-edge")
+	default:
+		return filepath.Join(config, "chromium")
+	}
+}
+
+// chromiumProfile returns the directory of a chromium profile. The profile
+// can be empty for the default profile, a profile directory name (e.g.
+// `Profile 1`) or an absolute path.
+func chromiumProfile(root, profile string) (string, error) {
+	if filepath.IsAbs(profile) {
+		return profile, nil
+	}
+	if profile == "" {
+		profile = "Default"
+	}
+	dir := filepath.Join(root, profile)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("browser: chromium profile %q not found: %w", profile, err)
+	}
+	return dir, nil
+}
+
+// chromiumDB returns the path of the cookie database of a chromium profile.
+func chromiumDB(dir string) (string, error) {
+	// Newer versions keep the database inside the Network directory.
+	candidates := []string{
+		filepath.Join(dir, "Network", "Cookies"),
+		filepath.Join(dir, "Cookies"),
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(c); err == nil {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("browser: couldn't find chromium cookie database in %s", dir)
+}
+
+// chromiumEpochOffset is the number of microseconds between the chromium
+// epoch (1601-01-01) and the unix epoch.
+const chromiumEpochOffset = 11644473600 * 1000000
+
+// readChromium reads the cookies of the host from a chromium Cookies database.
+// Only unencrypted values are supported.
+func readChromium(ctx context.Context, path, host string, now time.Time) ([]cookie, error) {
+	db, closer, err := openCopy(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	candidates := hostCandidates(host)
+	query := fmt.Sprintf("SELECT name, value, encrypted_value, host_key, expires_utc FROM cookies WHERE host_key IN (%s) ORDER BY creation_utc", placeholders(len(candidates)))
+	rows, err := db.QueryContext(ctx, query, toArgs(candidates)...)
+	if err != nil {
+		return nil, fmt.Errorf("browser: couldn't query chromium cookies: %w", err)
+	}
+	defer rows.Close()
+
+	var cookies []cookie
+	for rows.Next() {
+		var c cookie
+		var encrypted []byte
+		var expires int64
+		if err := rows.Scan(&c.name, &c.value, &encrypted, &c.host, &expires); err != nil {
+			return nil, fmt.Errorf("browser: couldn't scan chromium cookie: %w", err)
+		}
+		// A zero expiration is used by session cookies.
+		if expires > 0 && time.UnixMicro(expires-chromiumEpochOffset).Before(now) {
+			continue
+		}
+		if c.value == "" && len(encrypted) > 0 {
+			return nil, fmt.Errorf("browser: cookie %s is encrypted, only unencrypted chromium databases are supported", c.name)
+		}
+		cookies = append(cookies, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("browser: couldn't read chromium cookies: %w", err)
+	}
+	return cookies, nil
+}
@@ -0,0 +1,162 @@
+package browser
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// firefoxRoot returns the firefox directory containing profiles.ini.
+func firefoxRoot() string {
+	home := homeDir()
+	candidates := []string{
+		filepath.Join(home, ".mozilla", "firefox"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(filepath.Join(c, "profiles.ini")); err == nil {
+			return c
+		}
+	}
+	return candidates[0]
+}
+
+type firefoxProfileEntry struct {
+	name       string
+	path       string
+	isRelative bool
+	isDefault  bool
+}
+
+// firefoxProfile returns the directory of a firefox profile. The profile can
+// be empty for the default profile, a profile name, a profile directory name
+// or an absolute path.
+func firefoxProfile(root, profile string) (string, error) {
+	if filepath.IsAbs(profile) {
+		return profile, nil
+	}
+	ini := filepath.Join(root, "profiles.ini")
+	f, err := os.Open(ini)
+	if err != nil {
+		return "", fmt.Errorf("browser: couldn't open firefox profiles: %w", err)
+	}
+	defer f.Close()
+
+	var entries []*firefoxProfileEntry
+	var installDefaults []string
+	var current *firefoxProfileEntry
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			current = nil
+			if strings.HasPrefix(section, "Profile") {
+				current = &firefoxProfileEntry{isRelative: true}
+				entries = append(entries, current)
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(section, "Install") && k == "Default":
+			installDefaults = append(installDefaults, v)
+		case current == nil:
+		case k == "Name":
+			current.name = v
+		case k == "Path":
+			current.path = v
+		case k == "IsRelative":
+			current.isRelative = v == "1"
+		case k == "Default":
+			current.isDefault = v == "1"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("browser: couldn't read firefox profiles: %w", err)
+	}
+
+	dir := func(e *firefoxProfileEntry) string {
+		if e.isRelative {
+			return filepath.Join(root, filepath.FromSlash(e.path))
+		}
+		return e.path
+	}
+	if profile == "" {
+		// Install sections point to the profile used by each installation,
+		// they take precedence over the legacy default flag.
+		for _, d := range installDefaults {
+			for _, e := range entries {
+				if e.path == d {
+					return dir(e), nil
+				}
+			}
+		}
+		for _, e := range entries {
+			if e.isDefault {
+				return dir(e), nil
+			}
+		}
+		if len(entries) == 1 {
+			return dir(entries[0]), nil
+		}
+		return "", fmt.Errorf("browser: couldn't find default firefox profile in %s", ini)
+	}
+	for _, e := range entries {
+		if e.name == profile || e.path == profile || filepath.Base(e.path) == profile {
+			return dir(e), nil
+		}
+	}
+	return "", fmt.Errorf("browser: firefox profile %q not found in %s", profile, ini)
+}
+
+// readFirefox reads the cookies of the host from a firefox cookies.sqlite
+// database.
+func readFirefox(ctx context.Context, path, host string, now time.Time) ([]cookie, error) {
+	db, closer, err := openCopy(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	candidates := hostCandidates(host)
+	query := fmt.Sprintf("SELECT name, value, host, expiry FROM moz_cookies WHERE host IN (%s) ORDER BY id", placeholders(len(candidates)))
+	rows, err := db.QueryContext(ctx, query, toArgs(candidates)...)
+	if err != nil {
+		return nil, fmt.Errorf("browser: couldn't query firefox cookies: %w", err)
+	}
+	defer rows.Close()
+
+	var cookies []cookie
+	for rows.Next() {
+		var c cookie
+		var expiry int64
+		if err := rows.Scan(&c.name, &c.value, &c.host, &expiry); err != nil {
+			return nil, fmt.Errorf("browser: couldn't scan firefox cookie: %w", err)
+		}
+		// Recent firefox versions store the expiry in milliseconds.
+		exp := time.Unix(expiry, 0)
+		if expiry > 1e11 {
+			exp = time.UnixMilli(expiry)
+		}
+		if expiry > 0 && exp.Before(now) {
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("browser: couldn't read firefox cookies: %w", err)
+	}
+	return cookies, nil
+}
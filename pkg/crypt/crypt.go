@@ -0,0 +1,89 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// magic identifies encrypted data and its format version.
+var magic = []byte("LEONAI\x01")
+
+const saltSize = 16
+
+// ErrNotEncrypted is returned when decrypting data that isn't encrypted.
+var ErrNotEncrypted = errors.New("crypt: data is not encrypted")
+
+// IsEncrypted reports whether data was generated by Encrypt.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Encrypt encrypts and authenticates plaintext with AES-256-GCM using a key
+// derived from secret with scrypt and a random salt.
+func Encrypt(secret, plaintext []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("crypt: couldn't generate salt: %w", err)
+	}
+	aead, err := newAEAD(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("crypt: couldn't generate nonce: %w", err)
+	}
+	header := append(append(append([]byte{}, magic...), salt...), nonce...)
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// Decrypt decrypts data generated by Encrypt.
+func Decrypt(secret, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+	if len(data) < len(magic)+saltSize {
+		return nil, errors.New("crypt: data too short")
+	}
+	salt := data[len(magic) : len(magic)+saltSize]
+	aead, err := newAEAD(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(magic) + saltSize + aead.NonceSize()
+	if len(data) < headerSize {
+		return nil, errors.New("crypt: data too short")
+	}
+	header := data[:headerSize]
+	nonce := data[len(magic)+saltSize : headerSize]
+	plaintext, err := aead.Open(nil, nonce, data[headerSize:], header)
+	if err != nil {
+		return nil, errors.New("crypt: couldn't decrypt data, wrong key or corrupted data")
+	}
+	return plaintext, nil
+}
+
+func newAEAD(secret, salt []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("crypt: empty secret")
+	}
+	key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: couldn't derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: couldn't create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: couldn't create gcm: %w", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,39 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	secret := []byte("passphrase")
+	plaintext := []byte("__Secure-next-auth.session-token=value")
+	data, err := Encrypt(secret, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) {
+		t.Fatal("expected encrypted data")
+	}
+	if bytes.Contains(data, plaintext) {
+		t.Fatal("plaintext found in encrypted data")
+	}
+	got, err := Decrypt(secret, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("got %q, want %q", got, plaintext)
+	}
+
+	if _, err := Decrypt([]byte("wrong"), data); err == nil {
+		t.Error("expected error with wrong secret")
+	}
+	data[len(data)-1] ^= 0xff
+	if _, err := Decrypt(secret, data); err == nil {
+		t.Error("expected error with tampered data")
+	}
+	if _, err := Decrypt(secret, plaintext); err != ErrNotEncrypted {
+		t.Errorf("got %v, want %v", err, ErrNotEncrypted)
+	}
+}
@@ -0,0 +1,154 @@
+// Package journal keeps track of submitted generations on disk, so they can
+// be finished if the process is interrupted.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Job states.
+const (
+	// StatePending is a job that was submitted and isn't finished yet.
+	StatePending = "pending"
+	// StateDone is a job whose output was obtained.
+	StateDone = "done"
+	// StateFailed is a job whose generation failed.
+	StateFailed = "failed"
+	// StateSkipped is a job whose output wasn't downloaded because it was
+	// flagged as NSFW.
+	StateSkipped = "skipped"
+)
+
+// Job is a submitted generation.
+type Job struct {
+	// ID is the generation id.
+	ID             string    `json:"id"`
+	Image          string    `json:"image"`
+	ImageID        string    `json:"image_id"`
+	MotionStrength int       `json:"motion_strength"`
+	Output         string    `json:"output,omitempty"`
+	State          string    `json:"state"`
+	VideoURL       string    `json:"video_url,omitempty"`
+	NSFW           bool      `json:"nsfw,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Journal stores each job as a JSON file in a directory.
+type Journal struct {
+	dir string
+}
+
+// DefaultDir returns the default journal directory inside the user config
+// directory.
+func DefaultDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "leonai", "jobs")
+}
+
+// Open opens the journal in dir, creating the directory if needed.
+func Open(dir string) (*Journal, error) {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("journal: couldn't create directory: %w", err)
+	}
+	return &Journal{dir: dir}, nil
+}
+
+// Dir returns the directory of the journal.
+func (j *Journal) Dir() string {
+	return j.dir
+}
+
+// Save writes the job, replacing any previous version.
+func (j *Journal) Save(job *Job) error {
+	if job.ID == "" {
+		return errors.New("journal: job id is empty")
+	}
+	now := time.Now().UTC()
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = now
+	}
+	job.UpdatedAt = now
+	b, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return fmt.Errorf("journal: couldn't marshal job: %w", err)
+	}
+
+	// Write to a temp file and rename it, so a crash never leaves a broken
+	// job file
+	tmp, err := os.CreateTemp(j.dir, ".job-*")
+	if err != nil {
+		return fmt.Errorf("journal: couldn't create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("journal: couldn't write job: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("journal: couldn't sync job: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("journal: couldn't close job: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), j.path(job.ID)); err != nil {
+		return fmt.Errorf("journal: couldn't save job: %w", err)
+	}
+	return nil
+}
+
+// Get returns the job with the given id.
+func (j *Journal) Get(id string) (*Job, error) {
+	b, err := os.ReadFile(j.path(id))
+	if err != nil {
+		return nil, fmt.Errorf("journal: couldn't read job %s: %w", id, err)
+	}
+	var job Job
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, fmt.Errorf("journal: couldn't unmarshal job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// List returns all the jobs sorted by creation time.
+func (j *Journal) List() ([]*Job, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, fmt.Errorf("journal: couldn't read directory: %w", err)
+	}
+	var jobs []*Job
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		job, err := j.Get(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].CreatedAt.Before(jobs[b].CreatedAt)
+	})
+	return jobs, nil
+}
+
+func (j *Journal) path(id string) string {
+	return filepath.Join(j.dir, filepath.Base(id)+".json")
+}
@@ -0,0 +1,45 @@
+package journal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	j, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &Job{ID: "gen-1", Image: "car.jpg", Output: "car.mp4", State: StatePending}
+	if err := j.Save(first); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := j.Save(&Job{ID: "gen-2", State: StatePending}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update the first job
+	first.State = StateDone
+	first.VideoURL = "https://cdn.leonardo.ai/car.mp4"
+	if err := j.Save(first); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := j.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(jobs))
+	}
+	if jobs[0].ID != "gen-1" || jobs[1].ID != "gen-2" {
+		t.Errorf("unexpected order %s %s", jobs[0].ID, jobs[1].ID)
+	}
+	if jobs[0].State != StateDone || jobs[0].Output != "car.mp4" || jobs[0].VideoURL == "" {
+		t.Errorf("unexpected job %+v", jobs[0])
+	}
+	if !jobs[0].UpdatedAt.After(jobs[0].CreatedAt) {
+		t.Errorf("updated at %s isn't after created at %s", jobs[0].UpdatedAt, jobs[0].CreatedAt)
+	}
+}
@@ -0,0 +1,124 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Authenticator provides the credentials used to call the API.
+type Authenticator interface {
+	// Start is called by Client.Start with the client it belongs to.
+	Start(ctx context.Context, c *Client) error
+	// Stop is called by Client.Stop.
+	Stop(ctx context.Context) error
+	// Credentials returns valid credentials, renewing them if needed.
+	Credentials(ctx context.Context) (*Credentials, error)
+	// Refresh renews the credentials after the API rejected the given token.
+	// It does nothing if the token was already replaced, so concurrent
+	// requests rejected with the same token only renew it once.
+	Refresh(ctx context.Context, rejected string) error
+}
+
+// Credentials are used to authenticate API requests.
+type Credentials struct {
+	// Token is sent as a bearer token.
+	Token string
+	// UserID is the id of the user the token belongs to.
+	UserID string
+}
+
+type tokenAuth struct {
+	token  string
+	userID string
+}
+
+// NewTokenAuthenticator creates an authenticator that always uses the same
+// bearer token. If userID is empty, it is obtained from the claims of the
+// token when it is a Leonardo JWT.
+func NewTokenAuthenticator(token, userID string) Authenticator {
+	return &tokenAuth{
+		token:  token,
+		userID: userID,
+	}
+}
+
+func (a *tokenAuth) Start(ctx context.Context, c *Client) error {
+	if a.token == "" {
+		return errors.New("leonardo: token is empty")
+	}
+	if a.userID != "" {
+		return nil
+	}
+	if cls, err := toClaims(a.token); err == nil {
+		a.userID = cls.HasuraClaims.XHasuraUserID
+	}
+	return nil
+}
+
+func (a *tokenAuth) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (a *tokenAuth) Credentials(ctx context.Context) (*Credentials, error) {
+	return &Credentials{
+		Token:  a.token,
+		UserID: a.userID,
+	}, nil
+}
+
+func (a *tokenAuth) Refresh(ctx context.Context, rejected string) error {
+	return fmt.Errorf("leonardo: static token was rejected and can't be refreshed: %w", ErrUnauthorized)
+}
+
+type apiKeyAuth struct {
+	key    string
+	userID string
+}
+
+// NewAPIKeyAuthenticator creates an authenticator that uses an official
+// Leonardo API key.
+func NewAPIKeyAuthenticator(key string) Authenticator {
+	return &apiKeyAuth{
+		key: key,
+	}
+}
+
+type meResponse struct {
+	UserDetails []struct {
+		User struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"user"`
+	} `json:"user_details"`
+}
+
+func (a *apiKeyAuth) Start(ctx context.Context, c *Client) error {
+	if a.key == "" {
+		return errors.New("leonardo: api key is empty")
+	}
+	var resp meResponse
+	if _, err := c.do(ctx, "GetMe", "GET", c.restURL+"me", nil, &resp); err != nil {
+		return fmt.Errorf("leonardo: couldn't get api key user: %w", err)
+	}
+	if len(resp.UserDetails) == 0 || resp.UserDetails[0].User.ID == "" {
+		return errors.New("leonardo: empty api key user id")
+	}
+	a.userID = resp.UserDetails[0].User.ID
+	return nil
+}
+
+func (a *apiKeyAuth) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (a *apiKeyAuth) Credentials(ctx context.Context) (*Credentials, error) {
+	return &Credentials{
+		Token:  a.key,
+		UserID: a.userID,
+	}, nil
+}
+
+func (a *apiKeyAuth) Refresh(ctx context.Context, rejected string) error {
+	return fmt.Errorf("leonardo: api key was rejected: %w", ErrUnauthorized)
+}
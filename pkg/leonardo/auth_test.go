@@ -0,0 +1,225 @@
+package leonardo
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"sub","https://hasura.io/jwt/claims":"{\"x-hasura-user-id\":\"user\"}"}`))
+	token := "header." + payload + ".signature"
+
+	c := New(&Config{Authenticator: NewTokenAuthenticator(token, "")})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.userID != "user" {
+		t.Errorf("got user id %q, want %q", c.userID, "user")
+	}
+	creds, err := c.auth.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Token != token {
+		t.Errorf("got token %q, want %q", creds.Token, token)
+	}
+	if err := c.auth.Refresh(context.Background(), token); err == nil {
+		t.Error("expected static token refresh to fail")
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/rest/v1/me" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"user_details":[{"user":{"id":"user-1","username":"name"}}]}`)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr error
+	}{
+		{"valid", "key", nil},
+		{"rejected", "other", ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(&Config{
+				Wait:          time.Millisecond,
+				Authenticator: NewAPIKeyAuthenticator(tt.key),
+				RESTURL:       srv.URL + "/api/rest/v1/",
+			})
+			err := c.Start(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.userID != "user-1" {
+				t.Errorf("got user id %q, want user-1", c.userID)
+			}
+			// API keys use the official API by default
+			if _, ok := c.backend.(*restBackend); !ok {
+				t.Errorf("got backend %T, want rest", c.backend)
+			}
+		})
+	}
+}
+
+// refreshAuth is an authenticator whose token is only valid after the first
+// refresh.
+type refreshAuth struct {
+	lock      sync.Mutex
+	token     string
+	refreshes int
+}
+
+func (a *refreshAuth) Start(ctx context.Context, c *Client) error { return nil }
+
+func (a *refreshAuth) Stop(ctx context.Context) error { return nil }
+
+func (a *refreshAuth) Credentials(ctx context.Context) (*Credentials, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return &Credentials{Token: a.token, UserID: "user-1"}, nil
+}
+
+func (a *refreshAuth) Refresh(ctx context.Context, rejected string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.token != rejected {
+		return nil
+	}
+	a.refreshes++
+	a.token = "new"
+	return nil
+}
+
+func TestRefreshInvalidJWT(t *testing.T) {
+	var lock sync.Mutex
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		lock.Unlock()
+		if r.Header.Get("Authorization") != "Bearer new" {
+			fmt.Fprint(w, `{"errors":[{"message":"Could not verify JWT: JWTExpired","extensions":{"code":"invalid-jwt"}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{}}`)
+	}))
+	defer srv.Close()
+
+	auth := &refreshAuth{token: "old"}
+	c := New(&Config{
+		Wait:          time.Millisecond,
+		Authenticator: auth,
+		APIURL:        srv.URL + "/v1/",
+		// API errors other than invalid-jwt aren't retried
+		Retry: &RetryPolicy{RetryCodes: []string{"none"}},
+	})
+	ctx := context.Background()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct{}
+	if _, err := c.do(ctx, "Op", "POST", "graphql", map[string]any{}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if auth.refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", auth.refreshes)
+	}
+	if want := []string{"Bearer old", "Bearer new"}; fmt.Sprint(tokens) != fmt.Sprint(want) {
+		t.Errorf("tokens = %q, want %q", tokens, want)
+	}
+}
+
+func TestRefreshInvalidJWTConcurrent(t *testing.T) {
+	var lock sync.Mutex
+	var sessions int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth/session":
+			lock.Lock()
+			sessions++
+			token := fmt.Sprintf("new-%d", sessions)
+			lock.Unlock()
+			fmt.Fprintf(w, `{"accessToken":%q,"accessTokenExpiry":%d}`, token, time.Now().Add(time.Hour).Unix())
+		case "/v1/graphql":
+			if r.Header.Get("Authorization") == "Bearer stale" {
+				fmt.Fprint(w, `{"errors":[{"message":"Could not verify JWT: JWTExpired","extensions":{"code":"invalid-jwt"}}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"data":{}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := NewCookieStore(filepath.Join(t.TempDir(), "cookie.txt"))
+	if err := store.SetCookie(ctx, "session=abc"); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&Config{
+		Wait:        time.Millisecond,
+		CookieStore: store,
+		AppURL:      srv.URL,
+		APIURL:      srv.URL + "/v1/",
+	})
+	a := c.auth.(*cookieAuth)
+	if err := a.loadCookie(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.(TokenStore).SetToken(ctx, &Token{
+		AccessToken: "stale",
+		Expiration:  time.Now().Add(time.Hour),
+		UserID:      "user",
+		CookieHash:  a.cookieHash(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Stop(ctx) }()
+
+	// All the requests are rejected with the same token, but it is only
+	// renewed once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp struct{}
+			if _, err := c.do(ctx, "Op", "POST", "graphql", map[string]any{}, &resp); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if sessions != 1 {
+		t.Errorf("got %d session requests, want 1", sessions)
+	}
+}
@@ -0,0 +1,123 @@
+package leonardo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Backends that can be used to call the API.
+const (
+	// BackendGraphQL uses the GraphQL API of the web app.
+	BackendGraphQL = "graphql"
+	// BackendREST uses the official REST API.
+	BackendREST = "rest"
+)
+
+// Generation statuses.
+const (
+	StatusPending  = "PENDING"
+	StatusComplete = "COMPLETE"
+	StatusFailed   = "FAILED"
+)
+
+// Generation is the state of a generation.
+type Generation struct {
+	ID        string
+	Status    string
+	CreatedAt string
+	NSFW      bool
+	// Reason is why the generation failed, only reported by the REST API.
+	Reason string
+	Images []GeneratedImage
+}
+
+// GeneratedImage is an image, or video, produced by a generation.
+type GeneratedImage struct {
+	ID           string
+	URL          string
+	MotionMP4URL string
+	NSFW         bool
+}
+
+// Flagged reports whether the generation or any of its images is flagged as
+// NSFW.
+func (g *Generation) Flagged() bool {
+	if g.NSFW {
+		return true
+	}
+	for _, img := range g.Images {
+		if img.NSFW {
+			return true
+		}
+	}
+	return false
+}
+
+// failure returns the error of a generation that finished without a result.
+// Generations flagged as NSFW or failed for moderation reasons are assumed to
+// be blocked by moderation.
+func (g *Generation) failure() error {
+	reason := strings.ToLower(g.Reason)
+	if g.Flagged() || strings.Contains(reason, "moderat") || strings.Contains(reason, "nsfw") {
+		return &ModerationError{ID: g.ID, Reason: g.Reason, NSFW: g.Flagged()}
+	}
+	return &ErrGenerationFailed{ID: g.ID, Status: g.Status, Reason: g.Reason}
+}
+
+// Video returns the generated video of a complete motion generation.
+// The video is returned even if it is flagged as NSFW, check its NSFW field.
+func (g *Generation) Video() (*GeneratedImage, error) {
+	if g.Status == StatusFailed {
+		return nil, g.failure()
+	}
+	if len(g.Images) == 0 {
+		return nil, fmt.Errorf("leonardo: couldn't get generated images")
+	}
+	img := g.Images[0]
+	if img.MotionMP4URL == "" {
+		return nil, fmt.Errorf("leonardo: empty motion mp4 url: %w", g.failure())
+	}
+	if img.ID == "" {
+		return nil, fmt.Errorf("leonardo: empty generated image id")
+	}
+	return &img, nil
+}
+
+// backend implements the API operations used by the client.
+type backend interface {
+	// createUpload returns a presigned location to upload an init image.
+	createUpload(ctx context.Context, fileType, ext string) (*uploadTarget, error)
+	// createMotion submits a motion generation and returns its id and its
+	// cost, zero if unknown.
+	createMotion(ctx context.Context, imageID string, motionStrength int) (string, int, error)
+	// getGeneration returns the current state of a generation. status is
+	// its last known status, if it is set it may avoid a status query.
+	getGeneration(ctx context.Context, id, status string) (*Generation, error)
+	// getStatuses returns the id and status of the generations found.
+	getStatuses(ctx context.Context, ids []string) ([]Generation, error)
+	// initImageExists reports whether an init image still exists.
+	initImageExists(ctx context.Context, id string) (bool, error)
+	// recentMotions returns the latest motion generations of the user.
+	recentMotions(ctx context.Context) ([]submittedMotion, error)
+}
+
+// newBackend returns the backend with the given name, or nil if it is
+// unknown. If the name is empty, the official API is used with API keys and
+// the web API otherwise.
+func newBackend(c *Client, name string) backend {
+	if name == "" {
+		name = BackendGraphQL
+		if _, ok := c.auth.(*apiKeyAuth); ok {
+			name = BackendREST
+		}
+	}
+	switch name {
+	case BackendGraphQL:
+		return &graphqlBackend{c: c}
+	case BackendREST:
+		return &restBackend{c: c}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,342 @@
+package leonardo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// standIn is a local server that mimics both the web GraphQL API and the
+// official REST API.
+type standIn struct {
+	lock  sync.Mutex
+	polls int
+	// uploaded is the key of the last uploaded file.
+	uploaded string
+	// uploadLength is the content length of the last upload.
+	uploadLength int64
+	// uploads is the number of files uploaded.
+	uploads int
+	// deleted makes the uploaded init image not found.
+	deleted bool
+	// submissions is the number of motion generations submitted.
+	submissions int
+	// subscriptions is the number of status subscriptions served.
+	subscriptions int
+	// noSocket rejects websocket connections.
+	noSocket bool
+	// subscriptionError is sent as an error message to the subscriptions.
+	subscriptionError string
+}
+
+// newStandInClient starts a client of the given backend against a stand-in
+// server for s. The stand-in fields of cfg are overwritten, cfg can be nil.
+func newStandInClient(t *testing.T, s *standIn, backend string, cfg *Config) *Client {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	cfg.Wait = time.Millisecond
+	cfg.AppURL = srv.URL
+	cfg.APIURL = srv.URL + "/v1/"
+	cfg.RESTURL = srv.URL + "/api/rest/v1/"
+	cfg.Authenticator = NewTokenAuthenticator(standInToken, "user-1")
+	cfg.Backend = backend
+	c := New(cfg)
+	c.pollInterval = time.Millisecond
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Stop(context.Background()) })
+	return c
+}
+
+// submit records a motion submission.
+func (s *standIn) submit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.submissions++
+}
+
+// recent returns the generations submitted as a JSON array with the given
+// init image field.
+func (s *standIn) recent(initImage string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.submissions == 0 {
+		return "[]"
+	}
+	createdAt := time.Now().UTC().Format("2006-01-02T15:04:05.000")
+	return fmt.Sprintf(`[{"id":"gen-1","status":"PENDING","motion":true,"motionStrength":5,"createdAt":%q,%s}]`, createdAt, initImage)
+}
+
+const (
+	standInToken  = "test-token"
+	standInFields = `{"Content-Type":"image/png","bucket":"bucket","key":"init/image.png","Policy":"policy"}`
+	standInMP4    = "https://cdn.leonardo.ai/gen-1/video.mp4"
+)
+
+// initImage reports whether the init image exists.
+func (s *standIn) initImage() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.uploads > 0 && !s.deleted
+}
+
+// status returns PENDING on the first poll and COMPLETE afterwards.
+func (s *standIn) status() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.polls++
+	if s.polls == 1 {
+		return StatusPending
+	}
+	return StatusComplete
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/upload" {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, _, err := r.FormFile("file"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.lock.Lock()
+		s.uploaded = r.FormValue("key")
+		s.uploadLength = r.ContentLength
+		s.uploads++
+		s.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveSubscription(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+standInToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Relative upload urls are resolved against the api url
+	uploadURL := "/upload"
+	switch {
+	case r.URL.Path == "/v1/graphql":
+		var req graphqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch req.OperationName {
+		case "CreateUploadInitImage":
+			fmt.Fprintf(w, `{"data":{"uploadInitImage":{"id":"init-1","fields":%q,"key":"init/image.png","url":%q}}}`, standInFields, uploadURL)
+		case "CreateMotionSvdGenerationJob":
+			s.submit()
+			fmt.Fprint(w, `{"data":{"motionSvdGenerationJob":{"generationId":"gen-1","apiCreditCost":25}}}`)
+		case "GetInitImage":
+			if s.initImage() {
+				fmt.Fprint(w, `{"data":{"init_images_by_pk":{"id":"init-1"}}}`)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"init_images_by_pk":null}}`)
+		case "GetAIGenerationFeedStatuses":
+			fmt.Fprintf(w, `{"data":{"generations":[{"id":"gen-1","status":%q}]}}`, s.status())
+		case "GetAIGenerationFeed":
+			if where, _ := req.Variables["where"].(map[string]any); where["motion"] != nil {
+				fmt.Fprintf(w, `{"data":{"generations":%s}}`, s.recent(`"init_image":{"id":"init-1"}`))
+				return
+			}
+			fmt.Fprintf(w, `{"data":{"generations":[{"id":"gen-1","status":"COMPLETE","generated_images":[{"id":"img-1","url":"https://cdn.leonardo.ai/gen-1/img.jpg","motionMP4URL":%q}]}]}}`, standInMP4)
+		default:
+			http.Error(w, "unknown operation "+req.OperationName, http.StatusBadRequest)
+		}
+	case r.URL.Path == "/api/rest/v1/init-image" && r.Method == http.MethodPost:
+		fmt.Fprintf(w, `{"uploadInitImage":{"id":"init-1","fields":%q,"key":"init/image.png","url":%q}}`, standInFields, uploadURL)
+	case r.URL.Path == "/api/rest/v1/init-image/init-1" && r.Method == http.MethodGet:
+		if s.initImage() {
+			fmt.Fprint(w, `{"init_images_by_pk":{"id":"init-1"}}`)
+			return
+		}
+		http.NotFound(w, r)
+	case r.URL.Path == "/api/rest/v1/generations-motion-svd" && r.Method == http.MethodPost:
+		s.submit()
+		fmt.Fprint(w, `{"motionSvdGenerationJob":{"generationId":"gen-1","apiCreditCost":25}}`)
+	case r.URL.Path == "/api/rest/v1/generations/gen-1" && r.Method == http.MethodGet:
+		fmt.Fprintf(w, `{"generations_by_pk":{"id":"gen-1","status":%q,"generated_images":[{"id":"img-1","url":"https://cdn.leonardo.ai/gen-1/img.jpg","motionMP4URL":%q}]}}`, s.status(), standInMP4)
+	case r.URL.Path == "/api/rest/v1/generations/user/user-1" && r.Method == http.MethodGet:
+		fmt.Fprintf(w, `{"generations":%s}`, s.recent(`"initImageId":"init-1"`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBackends(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(image, []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{BackendGraphQL, BackendREST} {
+		t.Run(name, func(t *testing.T) {
+			s := &standIn{}
+			c := newStandInClient(t, s, name, nil)
+			ctx := context.Background()
+
+			imageID, err := c.Upload(ctx, image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imageID != "init-1" {
+				t.Errorf("image id = %q, want init-1", imageID)
+			}
+			if s.uploaded != "init/image.png" {
+				t.Errorf("uploaded key = %q, want init/image.png", s.uploaded)
+			}
+
+			id, u, err := c.CreateMotion(ctx, imageID, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "img-1" || u != standInMP4 {
+				t.Errorf("motion = %q %q, want img-1 %s", id, u, standInMP4)
+			}
+			if s.polls < 2 {
+				t.Errorf("polls = %d, want at least 2", s.polls)
+			}
+
+			gen, err := c.GetGeneration(ctx, "gen-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gen.Status != StatusComplete || len(gen.Images) != 1 || !strings.HasSuffix(gen.Images[0].MotionMP4URL, ".mp4") {
+				t.Errorf("unexpected generation %+v", gen)
+			}
+		})
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	c := New(&Config{
+		Authenticator: NewTokenAuthenticator(standInToken, "user-1"),
+		Backend:       "soap",
+	})
+	if err := c.Start(context.Background()); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+}
+
+func TestAppURLCookies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/auth/session" {
+			http.NotFound(w, r)
+			return
+		}
+		c, err := r.Cookie("session")
+		if err != nil || c.Value != "abc" {
+			http.Error(w, "missing cookie", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "def", Path: "/"})
+		fmt.Fprint(w, `{"user":{"name":"name"},"accessToken":"token"}`)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "cookie.txt")
+	store := NewCookieStore(path)
+	ctx := context.Background()
+	if err := store.SetCookie(ctx, "session=abc"); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&Config{
+		Wait:        time.Millisecond,
+		CookieStore: store,
+		AppURL:      srv.URL,
+	})
+	s, err := c.Session(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "name" {
+		t.Errorf("name = %q, want name", s.Name)
+	}
+
+	// The rotated cookie is saved for the stand-in host
+	cookie, err := store.GetCookie(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie != "session=def" {
+		t.Errorf("cookie = %q, want session=def", cookie)
+	}
+}
+
+func TestSubmitAndWait(t *testing.T) {
+	s := &standIn{}
+	c := newStandInClient(t, s, BackendREST, nil)
+	ctx := context.Background()
+
+	// Submitting doesn't wait for the generation
+	id, err := c.SubmitMotion(ctx, "init-1", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "gen-1" || s.polls != 0 {
+		t.Errorf("id = %q polls = %d, want gen-1 and no polls", id, s.polls)
+	}
+
+	// The generation can be waited later, e.g. by another process
+	gen, err := c.WaitGeneration(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := gen.Video()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.MotionMP4URL != standInMP4 {
+		t.Errorf("video url = %q, want %s", video.MotionMP4URL, standInMP4)
+	}
+}
+
+func TestWaitGenerations(t *testing.T) {
+	for _, name := range []string{BackendGraphQL, BackendREST} {
+		t.Run(name, func(t *testing.T) {
+			s := &standIn{}
+			c := newStandInClient(t, s, name, nil)
+			ctx := context.Background()
+
+			statuses, err := c.GetStatuses(ctx, []string{"gen-1", "missing"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(statuses) != 2 || statuses[0] != StatusPending || statuses[1] != "" {
+				t.Errorf("statuses = %q, want [PENDING \"\"]", statuses)
+			}
+
+			gens, err := c.WaitGenerations(ctx, []string{"gen-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gens) != 1 || gens[0].Status != StatusComplete {
+				t.Fatalf("unexpected generations %+v", gens)
+			}
+			if _, err := gens[0].Video(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
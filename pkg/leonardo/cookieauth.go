@@ -0,0 +1,408 @@
+package leonardo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/leonai/pkg/session"
+)
+
+// cookieAuth authenticates using the browser session cookie: the session
+// endpoint returns a JWT access token whose hasura claims identify the user.
+type cookieAuth struct {
+	c               *Client
+	cookieStore     CookieStore
+	token           string
+	tokenExpiration time.Time
+	sessionExpires  time.Time
+	clockSkew       time.Duration
+	userID          string
+	tokenLock       sync.RWMutex
+	authLock        sync.Mutex
+	cookieLock      sync.Mutex
+	lastCookie      string
+	autoRefresh     bool
+	reloginWarning  time.Duration
+	onAuthEvent     func(AuthEvent)
+	refreshCancel   context.CancelFunc
+	refreshWG       sync.WaitGroup
+}
+
+func newCookieAuth(c *Client, cfg *Config) *cookieAuth {
+	reloginWarning := cfg.ReloginWarning
+	if reloginWarning == 0 {
+		reloginWarning = 24 * time.Hour
+	}
+	return &cookieAuth{
+		c:              c,
+		cookieStore:    cfg.CookieStore,
+		autoRefresh:    cfg.AutoRefresh,
+		reloginWarning: reloginWarning,
+		onAuthEvent:    cfg.OnAuthEvent,
+	}
+}
+
+func (a *cookieAuth) Start(ctx context.Context, c *Client) error {
+	if err := a.loadCookie(ctx); err != nil {
+		return err
+	}
+
+	// Reuse the token from a previous run if it is still valid
+	if a.loadToken(ctx) {
+		a.startRefresher()
+		return nil
+	}
+
+	// Authenticate
+	a.authLock.Lock()
+	err := a.refreshToken(ctx)
+	a.authLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Get user id
+	token, _ := a.tokenState()
+	cls, err := toClaims(token)
+	if err != nil {
+		return err
+	}
+	userID, err := c.user(ctx, cls.Sub)
+	if err != nil {
+		return err
+	}
+	if userID != cls.HasuraClaims.XHasuraUserID {
+		return fmt.Errorf("leonardo: user id mismatch: %s != %s", userID, cls.HasuraClaims.XHasuraUserID)
+	}
+	a.userID = userID
+	a.saveToken(ctx)
+	a.startRefresher()
+
+	return nil
+}
+
+func (a *cookieAuth) Stop(ctx context.Context) error {
+	a.stopRefresher()
+	return a.saveCookie(ctx)
+}
+
+func (a *cookieAuth) Credentials(ctx context.Context) (*Credentials, error) {
+	a.authLock.Lock()
+	defer a.authLock.Unlock()
+	token, expiration := a.tokenState()
+	if token == "" || !time.Now().Before(expiration) {
+		if err := a.refreshToken(ctx); err != nil {
+			return nil, err
+		}
+		token, _ = a.tokenState()
+	}
+	return &Credentials{
+		Token:  token,
+		UserID: a.userID,
+	}, nil
+}
+
+func (a *cookieAuth) Refresh(ctx context.Context, rejected string) error {
+	a.authLock.Lock()
+	defer a.authLock.Unlock()
+	// Another request already replaced the rejected token
+	if token, expiration := a.tokenState(); token != "" && token != rejected && time.Now().Before(expiration) {
+		return nil
+	}
+	a.invalidateToken(ctx)
+	return a.refreshToken(ctx)
+}
+
+// loadToken loads the cached token if the cookie store supports it and the
+// token is still valid and was obtained with the current cookie. It reports
+// whether the token was loaded.
+func (a *cookieAuth) loadToken(ctx context.Context) bool {
+	ts, ok := a.cookieStore.(TokenStore)
+	if !ok {
+		return false
+	}
+	t, err := ts.GetToken(ctx)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if t == nil || t.AccessToken == "" || t.UserID == "" || !time.Now().Before(t.Expiration) {
+		return false
+	}
+	if t.CookieHash != a.cookieHash() {
+		a.c.log("leonardo: cached token belongs to another cookie, dropping it")
+		if err := ts.SetToken(ctx, nil); err != nil {
+			log.Println(err)
+		}
+		return false
+	}
+	a.tokenLock.Lock()
+	a.token = t.AccessToken
+	a.tokenExpiration = t.Expiration
+	a.sessionExpires = t.SessionExpires
+	a.clockSkew = t.ClockSkew
+	a.tokenLock.Unlock()
+	a.userID = t.UserID
+	a.c.log("leonardo: using cached token, expires at %s", t.Expiration.Format(time.RFC3339))
+	return true
+}
+
+// saveToken caches the token if the cookie store supports it.
+func (a *cookieAuth) saveToken(ctx context.Context) {
+	ts, ok := a.cookieStore.(TokenStore)
+	if !ok {
+		return
+	}
+	a.tokenLock.RLock()
+	t := &Token{
+		AccessToken:    a.token,
+		Expiration:     a.tokenExpiration,
+		UserID:         a.userID,
+		SessionExpires: a.sessionExpires,
+		ClockSkew:      a.clockSkew,
+	}
+	a.tokenLock.RUnlock()
+	t.CookieHash = a.cookieHash()
+	if err := ts.SetToken(ctx, t); err != nil {
+		log.Println(err)
+	}
+}
+
+// cookieHash returns the hash of the last loaded or saved session cookie.
+func (a *cookieAuth) cookieHash() string {
+	a.cookieLock.Lock()
+	defer a.cookieLock.Unlock()
+	h := sha256.Sum256([]byte(a.lastCookie))
+	return hex.EncodeToString(h[:])
+}
+
+// invalidateToken drops the current token and its cached copy, so the next
+// call to Credentials gets a new one.
+func (a *cookieAuth) invalidateToken(ctx context.Context) {
+	a.tokenLock.Lock()
+	a.token = ""
+	a.tokenExpiration = time.Time{}
+	a.tokenLock.Unlock()
+	ts, ok := a.cookieStore.(TokenStore)
+	if !ok {
+		return
+	}
+	if err := ts.SetToken(ctx, nil); err != nil {
+		log.Println(err)
+	}
+}
+
+// loadCookie loads the cookie from the store into the cookie jar.
+func (a *cookieAuth) loadCookie(ctx context.Context) error {
+	if a.cookieStore == nil {
+		return errors.New("leonardo: cookie store is required")
+	}
+	cookie, err := a.cookieStore.GetCookie(ctx)
+	if err != nil {
+		return err
+	}
+	if cookie == "" {
+		return fmt.Errorf("leonardo: cookie is empty")
+	}
+	client := a.c.client
+	if err := session.SetCookies(client, a.c.appURL, cookie, nil); err != nil {
+		return fmt.Errorf("leonardo: couldn't set cookie: %w", err)
+	}
+	lastCookie, err := session.GetCookies(client, a.c.appURL)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't get cookie: %w", err)
+	}
+	a.cookieLock.Lock()
+	a.lastCookie = lastCookie
+	a.cookieLock.Unlock()
+
+	// Save the cookie as soon as the server rotates it, so it isn't lost if
+	// the process doesn't stop cleanly.
+	if _, ok := client.Jar.(*persistJar); !ok {
+		client.Jar = &persistJar{
+			CookieJar: client.Jar,
+			onSet: func() {
+				if err := a.saveCookie(context.Background()); err != nil {
+					log.Println(err)
+				}
+			},
+		}
+	}
+	return nil
+}
+
+// saveCookie saves the session cookie if it changed since it was last saved.
+// The cached token is saved again so it matches the new cookie.
+func (a *cookieAuth) saveCookie(ctx context.Context) error {
+	cookie, err := session.GetCookies(a.c.client, a.c.appURL)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't get cookie: %w", err)
+	}
+	a.cookieLock.Lock()
+	if cookie == a.lastCookie {
+		a.cookieLock.Unlock()
+		return nil
+	}
+	if err := a.cookieStore.SetCookie(ctx, cookie); err != nil {
+		a.cookieLock.Unlock()
+		return err
+	}
+	a.lastCookie = cookie
+	a.cookieLock.Unlock()
+
+	if token, expiration := a.tokenState(); token != "" && a.userID != "" && time.Now().Before(expiration) {
+		a.saveToken(ctx)
+	}
+	return nil
+}
+
+// persistJar is a cookie jar that notifies every time it receives cookies.
+type persistJar struct {
+	http.CookieJar
+	onSet func()
+}
+
+func (j *persistJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.CookieJar.SetCookies(u, cookies)
+	if len(cookies) > 0 {
+		j.onSet()
+	}
+}
+
+// refreshToken gets a new access token from the session. The expiration
+// times sent by the server are converted to the local clock using the server
+// timestamp, so clock drift doesn't lead to using expired tokens.
+// It must be called with authLock held.
+func (a *cookieAuth) refreshToken(ctx context.Context) error {
+	resp, sent, err := a.session(ctx)
+	if err != nil {
+		return err
+	}
+	var skew time.Duration
+	if resp.ServerTimestamp > 0 {
+		skew = unixTime(int64(resp.ServerTimestamp)).Sub(sent)
+	}
+	if skew > time.Minute || skew < -time.Minute {
+		a.c.log("leonardo: clock skew with the server is %s", skew.Round(time.Second))
+	}
+	expiration := unixTime(int64(resp.AccessTokenExpiry)).Add(-skew)
+	var sessionExpires time.Time
+	if t, err := time.Parse(time.RFC3339, resp.Expires); err == nil {
+		sessionExpires = t.Add(-skew)
+	}
+
+	now := time.Now()
+	a.tokenLock.Lock()
+	a.token = resp.AccessToken
+	// Set token expiration to 90% of the actual expiration
+	a.tokenExpiration = now.Add(expiration.Sub(now) * 90 / 100).UTC()
+	a.sessionExpires = sessionExpires
+	a.clockSkew = skew
+	a.tokenLock.Unlock()
+
+	if a.userID != "" {
+		a.saveToken(ctx)
+	}
+	return nil
+}
+
+// tokenState returns the access token and its expiration in local time.
+func (a *cookieAuth) tokenState() (string, time.Time) {
+	a.tokenLock.RLock()
+	defer a.tokenLock.RUnlock()
+	return a.token, a.tokenExpiration
+}
+
+type sessionResponse struct {
+	User struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Sub   string `json:"sub"`
+	} `json:"user"`
+	Expires             string `json:"expires"`
+	AccessToken         string `json:"accessToken"`
+	AccessTokenIssuedAt int    `json:"accessTokenIssuedAt"`
+	AccessTokenExpiry   int    `json:"accessTokenExpiry"`
+	ServerTimestamp     int    `json:"serverTimestamp"`
+}
+
+// ErrNoSession is returned when the cookie doesn't belong to a valid session.
+// It matches ErrUnauthorized.
+var ErrNoSession = fmt.Errorf("leonardo: empty access token, the session cookie may have expired: %w", ErrUnauthorized)
+
+// session requests the session and returns it along with the local time the
+// request was sent at.
+func (a *cookieAuth) session(ctx context.Context) (*sessionResponse, time.Time, error) {
+	var resp sessionResponse
+	now := time.Now()
+	if _, err := a.c.do(ctx, "GetSession", "GET", "api/auth/session", nil, &resp); err != nil {
+		return nil, time.Time{}, fmt.Errorf("leonardo: couldn't get session: %w", err)
+	}
+	if resp.AccessToken == "" {
+		return nil, time.Time{}, ErrNoSession
+	}
+	return &resp, now, nil
+}
+
+// Session contains the details of the logged in session.
+type Session struct {
+	Name              string
+	Email             string
+	Expires           time.Time
+	AccessTokenExpiry time.Time
+	ServerTime        time.Time
+	// ClockSkew is the server time minus the local time.
+	ClockSkew time.Duration
+}
+
+// Session returns the details of the current session. It is only available
+// with cookie authentication and it can be called without calling Start
+// first.
+func (c *Client) Session(ctx context.Context) (*Session, error) {
+	a, ok := c.auth.(*cookieAuth)
+	if !ok {
+		return nil, errors.New("leonardo: session is only available with cookie authentication")
+	}
+	if _, ok := c.client.Jar.(*persistJar); !ok {
+		if err := a.loadCookie(ctx); err != nil {
+			return nil, err
+		}
+	}
+	resp, now, err := a.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		Name:              resp.User.Name,
+		Email:             resp.User.Email,
+		AccessTokenExpiry: unixTime(int64(resp.AccessTokenExpiry)),
+	}
+	if resp.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, resp.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("leonardo: couldn't parse session expiration %q: %w", resp.Expires, err)
+		}
+		s.Expires = expires
+	}
+	if resp.ServerTimestamp > 0 {
+		s.ServerTime = unixTime(int64(resp.ServerTimestamp))
+		s.ClockSkew = s.ServerTime.Sub(now)
+	}
+	return s, nil
+}
+
+// unixTime converts a unix timestamp in seconds or milliseconds to time.
+func unixTime(ts int64) time.Time {
+	if ts > 1e11 {
+		return time.UnixMilli(ts)
+	}
+	return time.Unix(ts, 0)
+}
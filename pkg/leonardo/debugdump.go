@@ -0,0 +1,246 @@
+package leonardo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDebugRetention is the number of debug dumps kept by default.
+const DefaultDebugRetention = 100
+
+const (
+	debugPrefix = "leonai-"
+	redacted    = "[REDACTED]"
+)
+
+// sensitiveKeys are the header, query and JSON keys whose values are
+// redacted, in lower case.
+var sensitiveKeys = map[string]bool{
+	"authorization":        true,
+	"cookie":               true,
+	"set-cookie":           true,
+	"x-api-key":            true,
+	"accesstoken":          true,
+	"access_token":         true,
+	"token":                true,
+	"apikey":               true,
+	"api_key":              true,
+	"password":             true,
+	"policy":               true,
+	"x-amz-signature":      true,
+	"x-amz-security-token": true,
+	"x-amz-credential":     true,
+	"signature":            true,
+}
+
+var (
+	jwtRegexp       = regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]+`)
+	signatureRegexp = regexp.MustCompile(`(?i)((?:X-Amz-Signature|X-Amz-Security-Token|X-Amz-Credential|Signature)=)[^&"\s]+`)
+	// jsonValueRegexp matches sensitive values in JSON that can't be parsed,
+	// like truncated or escaped bodies in error messages.
+	jsonValueRegexp = regexp.MustCompile(`(?i)(\\?"(?:` + strings.Join(sortedKeys(sensitiveKeys), "|") + `)\\?"\s*:\s*\\?")[^"\\]*`)
+)
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, regexp.QuoteMeta(k))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type debugDump struct {
+	Operation string         `json:"operation"`
+	Time      time.Time      `json:"time"`
+	Duration  string         `json:"duration"`
+	Error     string         `json:"error,omitempty"`
+	Request   debugRequest   `json:"request"`
+	Response  *debugResponse `json:"response,omitempty"`
+}
+
+type debugRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   any         `json:"body,omitempty"`
+}
+
+type debugResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   any         `json:"body,omitempty"`
+}
+
+// dump writes a redacted dump of a failed request to the debug directory, if
+// it is set. resp may be nil if no response was received.
+func (c *Client) dump(op string, req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, start time.Time, err error) {
+	if c.debugDir == "" {
+		return
+	}
+	d := debugDump{
+		Operation: op,
+		Time:      start,
+		Duration:  time.Since(start).String(),
+		Request: debugRequest{
+			Method: req.Method,
+			URL:    redactURL(req.URL),
+			Header: redactHeader(req.Header),
+			Body:   redactBody(reqBody),
+		},
+	}
+	if req.ContentLength > 0 && reqBody == nil {
+		// Uploads are streamed and not kept
+		d.Request.Body = fmt.Sprintf("[%d bytes]", req.ContentLength)
+	}
+	if err != nil {
+		d.Error = redactString(err.Error())
+	}
+	if resp != nil {
+		d.Response = &debugResponse{
+			Status: resp.StatusCode,
+			Header: redactHeader(resp.Header),
+			Body:   redactBody(respBody),
+		}
+	}
+	if err := c.writeDump(&d); err != nil {
+		c.log("leonardo: couldn't write debug dump: %v", err)
+	}
+}
+
+func (c *Client) writeDump(d *debugDump) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.debugLock.Lock()
+	defer c.debugLock.Unlock()
+	if err := os.MkdirAll(c.debugDir, 0700); err != nil {
+		return err
+	}
+	// Names start with the time so they sort chronologically, the random
+	// suffix avoids collisions
+	pattern := fmt.Sprintf("%s%s-%s-*.json", debugPrefix, d.Time.UTC().Format("20060102-150405.000000"), d.Operation)
+	f, err := os.CreateTemp(c.debugDir, pattern)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.log("leonardo: debug dump written to %s", f.Name())
+	return c.pruneDumps()
+}
+
+// pruneDumps removes the oldest dumps over the retention limit. It must be
+// called with the debug lock held.
+func (c *Client) pruneDumps() error {
+	entries, err := os.ReadDir(c.debugDir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), debugPrefix) && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) <= c.debugRetention {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-c.debugRetention] {
+		if err := os.Remove(filepath.Join(c.debugDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func redactHeader(h http.Header) http.Header {
+	out := http.Header{}
+	for k, vs := range h {
+		if sensitiveKeys[strings.ToLower(k)] {
+			out[k] = []string{redacted}
+			continue
+		}
+		for _, v := range vs {
+			out[k] = append(out[k], redactString(v))
+		}
+	}
+	return out
+}
+
+func redactURL(u *url.URL) string {
+	r := *u
+	r.User = nil
+	q := r.Query()
+	for k := range q {
+		if sensitiveKeys[strings.ToLower(k)] {
+			q.Set(k, redacted)
+		}
+	}
+	r.RawQuery = strings.ReplaceAll(q.Encode(), url.QueryEscape(redacted), redacted)
+	return redactString(r.String())
+}
+
+// redactBody returns the body as JSON with the sensitive values redacted,
+// or as a redacted string if it isn't JSON.
+func redactBody(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return redactString(string(b))
+	}
+	return redactValue(v)
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if sensitiveKeys[strings.ToLower(k)] {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactValue(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = redactValue(e)
+		}
+		return v
+	case string:
+		// Some values, like the upload fields, are JSON encoded as strings
+		var inner any
+		if strings.HasPrefix(v, "{") && json.Unmarshal([]byte(v), &inner) == nil {
+			if b, err := json.Marshal(redactValue(inner)); err == nil {
+				return string(b)
+			}
+		}
+		return redactString(v)
+	default:
+		return v
+	}
+}
+
+func redactString(s string) string {
+	s = jwtRegexp.ReplaceAllString(s, redacted)
+	s = jsonValueRegexp.ReplaceAllString(s, "${1}"+redacted)
+	return signatureRegexp.ReplaceAllString(s, "${1}"+redacted)
+}
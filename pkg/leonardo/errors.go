@@ -0,0 +1,146 @@
+package leonardo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is returned when the credentials are rejected.
+	ErrUnauthorized = errors.New("leonardo: unauthorized")
+	// ErrInsufficientTokens is returned when the account doesn't have enough
+	// tokens or API credits for the operation.
+	ErrInsufficientTokens = errors.New("leonardo: insufficient tokens")
+	// ErrModerated is returned when the content is blocked by moderation.
+	ErrModerated = errors.New("leonardo: content moderated")
+	// ErrNotFound is returned when a generation or user doesn't exist.
+	ErrNotFound = errors.New("leonardo: not found")
+)
+
+// ErrGenerationFailed is returned when a generation finishes without a result.
+type ErrGenerationFailed struct {
+	ID     string
+	Status string
+	// Reason is the reason given by the API, if any.
+	Reason string
+}
+
+func (e *ErrGenerationFailed) Error() string {
+	msg := fmt.Sprintf("leonardo: generation %s failed with status %s", e.ID, e.Status)
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Reason)
+	}
+	return msg
+}
+
+// ModerationError is returned when content is blocked or flagged by
+// moderation. It matches ErrModerated.
+type ModerationError struct {
+	// ID is the id of the generation, empty if it wasn't created.
+	ID string
+	// Reason is the reason given by the API, if any.
+	Reason string
+	// NSFW is set if the generation or its images are flagged as NSFW.
+	NSFW bool
+	// Err is the API error that caused it, if any.
+	Err error
+}
+
+func (e *ModerationError) Error() string {
+	msg := "leonardo: content moderated"
+	if e.ID != "" {
+		msg = fmt.Sprintf("leonardo: generation %s moderated", e.ID)
+	}
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Reason)
+	}
+	return msg
+}
+
+func (e *ModerationError) Is(target error) bool {
+	return target == ErrModerated
+}
+
+func (e *ModerationError) Unwrap() error {
+	return e.Err
+}
+
+// moderationError returns a *ModerationError if err is an API error caused by
+// moderation, otherwise err is returned unchanged.
+func moderationError(err error) error {
+	var errAPI *APIError
+	if !errors.As(err, &errAPI) || !errAPI.Is(ErrModerated) {
+		return err
+	}
+	return &ModerationError{Reason: errAPI.Message, Err: err}
+}
+
+// APIError is an error returned in the body of an API response.
+type APIError struct {
+	// Code is the error code, like invalid-jwt.
+	Code string
+	// Message is the error message.
+	Message string
+	// Operation is the logical name of the operation that failed.
+	Operation string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if e.Code != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Code)
+	}
+	if e.Operation != "" {
+		msg = fmt.Sprintf("%s: %s", e.Operation, msg)
+	}
+	return "leonardo: " + msg
+}
+
+// Is matches the API error with ErrUnauthorized, ErrInsufficientTokens and
+// ErrModerated using its code and message.
+func (e *APIError) Is(target error) bool {
+	code := strings.ToLower(e.Code)
+	msg := strings.ToLower(e.Message)
+	switch target {
+	case ErrUnauthorized:
+		return code == invalidJWTCode || code == "access-denied" || code == "unauthorized"
+	case ErrInsufficientTokens:
+		return strings.Contains(code, "insufficient") ||
+			(strings.Contains(msg, "not enough") || strings.Contains(msg, "insufficient")) &&
+				(strings.Contains(msg, "token") || strings.Contains(msg, "credit"))
+	case ErrModerated:
+		return strings.Contains(code, "moderat") || strings.Contains(code, "nsfw") ||
+			strings.Contains(msg, "moderat") || strings.Contains(msg, "nsfw")
+	}
+	return false
+}
+
+// HTTPError is returned when the server responds with an unexpected status
+// code.
+type HTTPError struct {
+	Status int
+	// RetryAfter is the wait requested by the server with the Retry-After
+	// header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+}
+
+// Is matches 401 and 403 with ErrUnauthorized, 402 with
+// ErrInsufficientTokens and 404 with ErrNotFound.
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	case ErrInsufficientTokens:
+		return e.Status == http.StatusPaymentRequired
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/unauthorized":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case "/v1/graphql":
+			fmt.Fprint(w, `{"errors":[{"message":"Not enough tokens to generate","extensions":{"code":"unexpected"}}]}`)
+		case "/v1/rest":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"content moderated","code":"moderation-failed"}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(&Config{
+		Wait:          time.Millisecond,
+		Authenticator: NewTokenAuthenticator(standInToken, "user-1"),
+		APIURL:        srv.URL + "/v1/",
+	})
+	ctx := context.Background()
+
+	_, _, err := c.doAttempt(ctx, "Op", "GET", "unauthorized", nil, nil)
+	var errHTTP *HTTPError
+	if !errors.As(err, &errHTTP) || errHTTP.Status != http.StatusUnauthorized {
+		t.Errorf("expected http error, got %v", err)
+	}
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+
+	var out any
+	_, _, err = c.doAttempt(ctx, "CreateMotionSvdGenerationJob", "POST", "graphql", &graphqlRequest{}, &out)
+	var errAPI *APIError
+	if !errors.As(err, &errAPI) || errAPI.Operation != "CreateMotionSvdGenerationJob" || errAPI.Code != "unexpected" {
+		t.Errorf("expected api error, got %v", err)
+	}
+	if !errors.Is(err, ErrInsufficientTokens) || errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected insufficient tokens, got %v", err)
+	}
+
+	_, _, err = c.doAttempt(ctx, "GetGeneration", "GET", "rest", nil, &out)
+	if !errors.Is(err, ErrModerated) || !errors.As(err, &errHTTP) || !errors.As(err, &errAPI) {
+		t.Errorf("expected moderated api and http error, got %v", err)
+	}
+}
+
+func TestGenerationFailed(t *testing.T) {
+	var err error = fmt.Errorf("wrapped: %w", &ErrGenerationFailed{ID: "gen-1", Status: StatusFailed})
+	var errFailed *ErrGenerationFailed
+	if !errors.As(err, &errFailed) || errFailed.ID != "gen-1" || errFailed.Status != StatusFailed {
+		t.Errorf("expected generation failed, got %v", err)
+	}
+	if !errors.Is(ErrNoSession, ErrUnauthorized) {
+		t.Error("session errors should be unauthorized")
+	}
+}
+
+func TestModeration(t *testing.T) {
+	// API errors caused by moderation are typed
+	apiErr := &APIError{Code: "moderation-failed", Message: "prompt blocked", Operation: "CreateMotionSvdGenerationJob"}
+	err := moderationError(fmt.Errorf("wrapped: %w", apiErr))
+	var errModerated *ModerationError
+	if !errors.As(err, &errModerated) || errModerated.Reason != "prompt blocked" || !errors.Is(err, ErrModerated) {
+		t.Errorf("expected moderation error, got %v", err)
+	}
+	if err := moderationError(ErrInsufficientTokens); err != ErrInsufficientTokens {
+		t.Errorf("expected error unchanged, got %v", err)
+	}
+
+	// Failed generations flagged as NSFW are moderated
+	gen := &Generation{ID: "gen-1", Status: StatusFailed, Images: []GeneratedImage{{ID: "img-1", NSFW: true}}}
+	_, err = gen.Video()
+	if !errors.As(err, &errModerated) || errModerated.ID != "gen-1" || !errModerated.NSFW {
+		t.Errorf("expected nsfw moderation error, got %v", err)
+	}
+	gen.Images[0].NSFW = false
+	_, err = gen.Video()
+	var errFailed *ErrGenerationFailed
+	if !errors.As(err, &errFailed) || errors.Is(err, ErrModerated) {
+		t.Errorf("expected generation failed, got %v", err)
+	}
+
+	// The reason reported by the API is kept
+	gen.Reason = "Image blocked by moderation"
+	_, err = gen.Video()
+	if !errors.As(err, &errModerated) || errModerated.Reason != gen.Reason || errModerated.NSFW {
+		t.Errorf("expected moderation error with reason, got %v", err)
+	}
+	gen.Reason = "out of capacity"
+	_, err = gen.Video()
+	if !errors.As(err, &errFailed) || errFailed.Reason != gen.Reason || errors.Is(err, ErrModerated) {
+		t.Errorf("expected generation failed with reason, got %v", err)
+	}
+
+	// Complete NSFW videos are returned flagged
+	gen = &Generation{ID: "gen-1", Status: StatusComplete, Images: []GeneratedImage{{ID: "img-1", MotionMP4URL: "video.mp4", NSFW: true}}}
+	video, err := gen.Video()
+	if err != nil || !video.NSFW || !gen.Flagged() {
+		t.Errorf("expected flagged video, got %+v %v", video, err)
+	}
+}
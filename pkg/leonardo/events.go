@@ -0,0 +1,73 @@
+package leonardo
+
+import (
+	"log"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// EventUploadStarted is emitted before an image is uploaded.
+	EventUploadStarted EventType = "upload-started"
+	// EventUploadProgress is emitted while an image is uploaded.
+	EventUploadProgress EventType = "upload-progress"
+	// EventUploadDone is emitted when an image has been uploaded.
+	EventUploadDone EventType = "upload-done"
+	// EventJobSubmitted is emitted when a generation has been submitted.
+	EventJobSubmitted EventType = "job-submitted"
+	// EventStatusChanged is emitted when the status of a generation being
+	// waited changes.
+	EventStatusChanged EventType = "status-changed"
+	// EventAssetReady is emitted for each asset of a complete generation.
+	EventAssetReady EventType = "asset-ready"
+	// EventRetrying is emitted before a failed request is retried.
+	EventRetrying EventType = "retrying"
+)
+
+// Event describes the progress of the client operations. Only the fields
+// related to its type are set.
+type Event struct {
+	Type EventType
+	// Path is the file being uploaded.
+	Path string
+	// Sent is the number of bytes of the file uploaded so far.
+	Sent int64
+	// Total is the size of the file being uploaded.
+	Total int64
+	// ImageID is the id of the uploaded init image.
+	ImageID string
+	// Cached is set if the image wasn't uploaded because the same content
+	// had already been uploaded.
+	Cached bool
+	// GenerationID is the id of the generation.
+	GenerationID string
+	// Cost is the API credit cost of a submitted generation, zero if unknown.
+	Cost int
+	// Status is the new status of the generation.
+	Status string
+	// AssetID and URL identify a generated asset.
+	AssetID string
+	URL     string
+	// Operation is the name of the operation being retried.
+	Operation string
+	// Attempt is the number of the next attempt, starting at 2.
+	Attempt int
+	// Wait is the time until the next attempt.
+	Wait time.Duration
+	// Err is the reason of the retry.
+	Err error
+}
+
+// emit sends an event to the observer. If there isn't one, only retries are
+// logged.
+func (c *Client) emit(e Event) {
+	if c.onEvent != nil {
+		c.onEvent(e)
+		return
+	}
+	if e.Type == EventRetrying {
+		log.Println("retrying...", e.Err)
+	}
+}
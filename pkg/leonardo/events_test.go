@@ -0,0 +1,89 @@
+package leonardo
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(image, []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The first submission times out before reaching the server
+	var failed bool
+	fault := func(op string, next Doer) Doer {
+		return func(req *http.Request) (*http.Response, error) {
+			if op == "CreateMotionSvdGenerationJob" && !failed {
+				failed = true
+				return nil, timeoutError{}
+			}
+			return next(req)
+		}
+	}
+
+	var lock sync.Mutex
+	var events []Event
+	c := newStandInClient(t, &standIn{}, BackendGraphQL, &Config{
+		Middlewares: []Middleware{fault},
+		OnEvent: func(e Event) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, e)
+		},
+	})
+	ctx := context.Background()
+	imageID, err := c.Upload(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.CreateMotion(ctx, imageID, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []EventType
+	for _, e := range events {
+		if e.Type == EventUploadProgress {
+			continue
+		}
+		types = append(types, e.Type)
+		switch e.Type {
+		case EventUploadDone:
+			if e.ImageID != "init-1" || e.Total != 3 {
+				t.Errorf("upload done = %+v, want init-1 and 3 bytes", e)
+			}
+		case EventRetrying:
+			if e.Operation != "CreateMotionSvdGenerationJob" || e.Attempt != 2 || e.Err == nil {
+				t.Errorf("retrying = %+v, want second attempt of CreateMotionSvdGenerationJob", e)
+			}
+		case EventJobSubmitted:
+			if e.GenerationID != "gen-1" || e.Cost != 25 {
+				t.Errorf("job submitted = %+v, want gen-1 with cost 25", e)
+			}
+		case EventAssetReady:
+			if e.AssetID != "img-1" || e.URL != standInMP4 {
+				t.Errorf("asset ready = %+v, want img-1 %s", e, standInMP4)
+			}
+		}
+	}
+	want := []EventType{
+		EventUploadStarted,
+		EventUploadDone,
+		EventRetrying,
+		EventJobSubmitted,
+		EventStatusChanged,
+		EventStatusChanged,
+		EventAssetReady,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	if last := events[len(events)-2]; last.Status != StatusComplete {
+		t.Errorf("last status = %q, want %s", last.Status, StatusComplete)
+	}
+}
@@ -0,0 +1,310 @@
+package leonardo
+
+import (
+	"context"
+	"fmt"
+)
+
+// graphqlBackend uses the GraphQL API of the web app.
+type graphqlBackend struct {
+	c *Client
+}
+
+type createUploadResponse struct {
+	Data struct {
+		UploadInitImage struct {
+			ID     string `json:"id"`
+			Fields string `json:"fields"`
+			Key    string `json:"key"`
+			URL    string `json:"url"`
+		} `json:"uploadInitImage"`
+	} `json:"data"`
+}
+
+func (b *graphqlBackend) createUpload(ctx context.Context, fileType, ext string) (*uploadTarget, error) {
+	req := &graphqlRequest{
+		OperationName: "CreateUploadInitImage",
+		Variables: map[string]any{
+			"arg1": map[string]any{
+				"fileType":  fileType,
+				"extension": ext,
+			},
+		},
+		Query: uploadQuery,
+	}
+
+	var resp createUploadResponse
+	if _, err := b.c.do(ctx, req.OperationName, "POST", "graphql", req, &resp); err != nil {
+		return nil, err
+	}
+	upload := resp.Data.UploadInitImage
+	return b.c.newUploadTarget(upload.ID, upload.URL, upload.Fields)
+}
+
+type createGenerationResponse struct {
+	Data struct {
+		MotionSVDGenerationJob struct {
+			APICreditCost int    `json:"apiCreditCost"`
+			GenerationID  string `json:"generationId"`
+		} `json:"motionSvdGenerationJob"`
+	} `json:"data"`
+}
+
+type feedResponse struct {
+	Data struct {
+		Generations []generation `json:"generations"`
+	} `json:"data"`
+}
+
+type generation struct {
+	Alchemy             any    `json:"alchemy"`
+	ContrastRatio       any    `json:"contrastRatio"`
+	HighResolution      any    `json:"highResolution"`
+	GuidanceScale       any    `json:"guidanceScale"`
+	InferenceSteps      any    `json:"inferenceSteps"`
+	ModelId             any    `json:"modelId"`
+	Scheduler           any    `json:"scheduler"`
+	CoreModel           string `json:"coreModel"`
+	SdVersion           any    `json:"sdVersion"`
+	Prompt              string `json:"prompt"`
+	NegativePrompt      any    `json:"negativePrompt"`
+	ID                  string `json:"id"`
+	Status              string `json:"status"`
+	Quantity            int    `json:"quantity"`
+	CreatedAt           string `json:"createdAt"`
+	ImageHeight         int    `json:"imageHeight"`
+	ImageWidth          int    `json:"imageWidth"`
+	PresetStyle         any    `json:"presetStyle"`
+	Public              bool   `json:"public"`
+	Seed                int64  `json:"seed"`
+	Tiling              any    `json:"tiling"`
+	InitStrength        any    `json:"initStrength"`
+	ImageToImage        bool   `json:"imageToImage"`
+	HighContrast        bool   `json:"highContrast"`
+	PromptMagic         bool   `json:"promptMagic"`
+	PromptMagicVersion  any    `json:"promptMagicVersion"`
+	PromptMagicStrength any    `json:"promptMagicStrength"`
+	ImagePromptStrength any    `json:"imagePromptStrength"`
+	ExpandedDomain      any    `json:"expandedDomain"`
+	Motion              bool   `json:"motion"`
+	MotionStrength      int    `json:"motionStrength"`
+	PhotoReal           any    `json:"photoReal"`
+	PhotoRealStrength   any    `json:"photoRealStrength"`
+	Nsfw                bool   `json:"nsfw"`
+	User                struct {
+		Username string `json:"username"`
+		ID       string `json:"id"`
+		Typename string `json:"__typename"`
+	} `json:"user"`
+	CustomModel any `json:"custom_model"`
+	InitImage   struct {
+		ID       string `json:"id"`
+		URL      string `json:"url"`
+		Typename string `json:"__typename"`
+	} `json:"init_image"`
+	GeneratedImages []struct {
+		ID                              string        `json:"id"`
+		URL                             string        `json:"url"`
+		MotionGIFURL                    *string       `json:"motionGIFURL"`
+		MotionMP4URL                    *string       `json:"motionMP4URL"`
+		LikeCount                       int           `json:"likeCount"`
+		Nsfw                            bool          `json:"nsfw"`
+		GeneratedImageVariationGenerics []interface{} `json:"generated_image_variation_generics"`
+		Typename                        string        `json:"__typename"`
+	} `json:"generated_images"`
+	GenerationElements    []interface{} `json:"generation_elements"`
+	GenerationControlnets []interface{} `json:"generation_controlnets"`
+	Typename              string        `json:"__typename"`
+}
+
+type statusResponse struct {
+	Data struct {
+		Generations []generationStatus `json:"generations"`
+	} `json:"data"`
+}
+
+type generationStatus struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Typename string `json:"__typename"`
+}
+
+func (b *graphqlBackend) createMotion(ctx context.Context, imageID string, motionStrength int) (string, int, error) {
+	req := &graphqlRequest{
+		OperationName: "CreateMotionSvdGenerationJob",
+		Variables: map[string]any{
+			"arg1": map[string]any{
+				"imageId":        imageID,
+				"isPublic":       false,
+				"isInitImage":    true,
+				"isVariation":    false,
+				"motionStrength": motionStrength,
+			},
+		},
+		Query: createQuery,
+	}
+
+	var resp createGenerationResponse
+	var reused string
+	guard, unlock := b.c.motionGuard(ctx, imageID, motionStrength, &reused)
+	defer unlock()
+	if _, err := b.c.doGuarded(ctx, req.OperationName, "POST", "graphql", req, &resp, guard); err != nil {
+		return "", 0, err
+	}
+	if reused != "" {
+		return reused, 0, nil
+	}
+	return resp.Data.MotionSVDGenerationJob.GenerationID, resp.Data.MotionSVDGenerationJob.APICreditCost, nil
+}
+
+func (b *graphqlBackend) recentMotions(ctx context.Context) ([]submittedMotion, error) {
+	req := &graphqlRequest{
+		OperationName: "GetAIGenerationFeed",
+		Variables: map[string]any{
+			"where": map[string]any{
+				"userId": map[string]any{
+					"_eq": b.c.userID,
+				},
+				"motion": map[string]any{
+					"_eq": true,
+				},
+			},
+			"offset": 0,
+			"limit":  10,
+		},
+		Query: feedQuery,
+	}
+	var resp feedResponse
+	if _, err := b.c.do(ctx, req.OperationName, "POST", "graphql", req, &resp); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't get feed: %w", err)
+	}
+	var gens []submittedMotion
+	for _, g := range resp.Data.Generations {
+		createdAt, err := parseCreatedAt(g.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		gens = append(gens, submittedMotion{
+			ID:             g.ID,
+			InitImageID:    g.InitImage.ID,
+			MotionStrength: g.MotionStrength,
+			CreatedAt:      createdAt,
+		})
+	}
+	return gens, nil
+}
+
+func (b *graphqlBackend) getStatuses(ctx context.Context, ids []string) ([]Generation, error) {
+	req := &graphqlRequest{
+		OperationName: "GetAIGenerationFeedStatuses",
+		Variables: map[string]any{
+			"where": map[string]any{
+				"id": map[string]any{
+					"_in": ids,
+				},
+			},
+		},
+		Query: statusQuery,
+	}
+	var resp statusResponse
+	if _, err := b.c.do(ctx, req.OperationName, "POST", "graphql", req, &resp); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't get status: %w", err)
+	}
+	var gens []Generation
+	for _, g := range resp.Data.Generations {
+		gens = append(gens, Generation{ID: g.ID, Status: g.Status})
+	}
+	return gens, nil
+}
+
+func (b *graphqlBackend) getGeneration(ctx context.Context, id, status string) (*Generation, error) {
+	// The status query is cheap, the feed is only requested once the
+	// generation is finished to get its assets and flags.
+	s := Generation{ID: id, Status: status}
+	if status == "" {
+		statuses, err := b.getStatuses(ctx, []string{id})
+		if err != nil {
+			return nil, err
+		}
+		if len(statuses) == 0 {
+			return nil, fmt.Errorf("leonardo: couldn't find generation %s: %w", id, ErrNotFound)
+		}
+		s = statuses[0]
+	}
+	if s.Status != StatusComplete && s.Status != StatusFailed {
+		return &s, nil
+	}
+
+	feedReq := &graphqlRequest{
+		OperationName: "GetAIGenerationFeed",
+		Variables: map[string]any{
+			"where": map[string]any{
+				"userId": map[string]any{
+					"_eq": b.c.userID,
+				},
+				"id": map[string]any{
+					"_eq": id,
+				},
+			},
+			"offset": 0,
+			"limit":  1,
+		},
+		Query: feedQuery,
+	}
+	var feedResp feedResponse
+	if _, err := b.c.do(ctx, feedReq.OperationName, "POST", "graphql", feedReq, &feedResp); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't get feed: %w", err)
+	}
+	for _, g := range feedResp.Data.Generations {
+		if g.ID != id {
+			continue
+		}
+		gen := &Generation{
+			ID:        g.ID,
+			Status:    g.Status,
+			CreatedAt: g.CreatedAt,
+			NSFW:      g.Nsfw,
+		}
+		for _, img := range g.GeneratedImages {
+			var mp4 string
+			if img.MotionMP4URL != nil {
+				mp4 = *img.MotionMP4URL
+			}
+			gen.Images = append(gen.Images, GeneratedImage{
+				ID:           img.ID,
+				URL:          img.URL,
+				MotionMP4URL: mp4,
+				NSFW:         img.Nsfw,
+			})
+		}
+		return gen, nil
+	}
+	if s.Status == StatusFailed {
+		// Failed generations may be hidden from the feed
+		return &s, nil
+	}
+	return nil, fmt.Errorf("leonardo: couldn't find generation %s in feed: %w", id, ErrNotFound)
+}
+
+type initImageResponse struct {
+	Data struct {
+		InitImagesByPK *struct {
+			ID string `json:"id"`
+		} `json:"init_images_by_pk"`
+	} `json:"data"`
+}
+
+func (b *graphqlBackend) initImageExists(ctx context.Context, id string) (bool, error) {
+	req := &graphqlRequest{
+		OperationName: "GetInitImage",
+		Variables: map[string]any{
+			"id": id,
+		},
+		Query: initImageQuery,
+	}
+	var resp initImageResponse
+	if _, err := b.c.do(ctx, req.OperationName, "POST", "graphql", req, &resp); err != nil {
+		return false, fmt.Errorf("leonardo: couldn't get init image: %w", err)
+	}
+	return resp.Data.InitImagesByPK != nil, nil
+}
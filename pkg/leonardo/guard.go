@@ -0,0 +1,149 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// submitGuard reports whether a request that failed was already processed
+// by the server.
+type submitGuard func(ctx context.Context) (bool, error)
+
+// submitGuardMargin is how long before the attempt, in server time, a
+// generation can be created to be considered its result. It covers the error
+// of the measured clock skew.
+const submitGuardMargin = time.Second
+
+// maybeSubmitted reports whether the request may have reached the server
+// even if it failed: the connection was lost or a gateway failed. Requests
+// that failed to resolve or connect never reached it.
+func maybeSubmitted(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect") {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var errStatus *HTTPError
+	if errors.As(err, &errStatus) {
+		return errStatus.Status >= 500 && errStatus.Status != http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// submittedMotion is a motion generation found in the feed.
+type submittedMotion struct {
+	ID             string
+	InitImageID    string
+	MotionStrength int
+	CreatedAt      time.Time
+}
+
+// motionGuard returns a guard that looks for a new motion generation of the
+// init image with the same motion strength. If found, its id is stored in
+// generationID.
+// The feed is listed before submitting, so generations that already existed
+// aren't taken as the result. Submissions of the same motion are serialized
+// until unlock is called, so concurrent jobs don't take each other's result.
+func (c *Client) motionGuard(ctx context.Context, imageID string, motionStrength int, generationID *string) (submitGuard, func()) {
+	unlock := c.lockSubmit(fmt.Sprintf("%s:%d", imageID, motionStrength))
+	since := c.serverNow()
+	known := map[string]struct{}{}
+	gens, listErr := c.backend.recentMotions(ctx)
+	for _, g := range gens {
+		known[g.ID] = struct{}{}
+	}
+	guard := func(ctx context.Context) (bool, error) {
+		if listErr != nil {
+			return false, fmt.Errorf("leonardo: couldn't list generations before submitting: %w", listErr)
+		}
+		gens, err := c.backend.recentMotions(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, g := range gens {
+			if _, ok := known[g.ID]; ok {
+				continue
+			}
+			if g.InitImageID != imageID || g.MotionStrength != motionStrength || g.CreatedAt.Before(since.Add(-submitGuardMargin)) {
+				continue
+			}
+			log.Printf("leonardo: motion of image %s was already submitted as generation %s, reusing it\n", imageID, g.ID)
+			*generationID = g.ID
+			return true, nil
+		}
+		log.Printf("leonardo: motion of image %s wasn't submitted, submitting it again\n", imageID)
+		return false, nil
+	}
+	return guard, unlock
+}
+
+// lockSubmit locks the submissions with the given key and returns the
+// function that unlocks them.
+func (c *Client) lockSubmit(key string) func() {
+	c.submitLock.Lock()
+	if c.submitLocks == nil {
+		c.submitLocks = map[string]*submitLock{}
+	}
+	l, ok := c.submitLocks[key]
+	if !ok {
+		l = &submitLock{}
+		c.submitLocks[key] = l
+	}
+	l.refs++
+	c.submitLock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.submitLock.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.submitLocks, key)
+		}
+		c.submitLock.Unlock()
+	}
+}
+
+type submitLock struct {
+	sync.Mutex
+	refs int
+}
+
+// serverNow returns the current time of the server, using the clock skew
+// measured by the cookie authenticator.
+func (c *Client) serverNow() time.Time {
+	now := time.Now()
+	if a, ok := c.auth.(*cookieAuth); ok {
+		a.tokenLock.RLock()
+		now = now.Add(a.clockSkew)
+		a.tokenLock.RUnlock()
+	}
+	return now
+}
+
+// parseCreatedAt parses the creation time of a generation, the API returns
+// UTC times without time zone.
+func parseCreatedAt(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05.999999999", v, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("leonardo: couldn't parse creation time %q: %w", v, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,91 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestSubmitGuard(t *testing.T) {
+	tests := []struct {
+		name string
+		// reached is whether the failed submission reaches the server
+		reached bool
+		// strength is the motion strength submitted, the stand-in reports
+		// generations with strength 5
+		strength int
+		// existing is the number of generations already in the feed
+		existing        int
+		wantSubmissions int
+	}{
+		{"response lost", true, 5, 0, 1},
+		{"request lost", false, 5, 0, 1},
+		{"other strength", true, 3, 0, 2},
+		{"earlier generation", false, 5, 1, 1},
+	}
+	for _, backend := range []string{BackendGraphQL, BackendREST} {
+		for _, tt := range tests {
+			t.Run(backend+" "+tt.name, func(t *testing.T) {
+				s := &standIn{submissions: tt.existing}
+
+				// The first submission times out
+				failed := false
+				fault := func(op string, next Doer) Doer {
+					return func(req *http.Request) (*http.Response, error) {
+						if op != "CreateMotionSvdGenerationJob" || failed {
+							return next(req)
+						}
+						failed = true
+						if tt.reached {
+							resp, err := next(req)
+							if err == nil {
+								resp.Body.Close()
+							}
+						}
+						return nil, timeoutError{}
+					}
+				}
+				c := newStandInClient(t, s, backend, &Config{
+					Middlewares: []Middleware{fault},
+				})
+				ctx := context.Background()
+				id, _, err := c.backend.createMotion(ctx, "init-1", tt.strength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id != "gen-1" {
+					t.Errorf("generation id = %q, want gen-1", id)
+				}
+				if s.submissions-tt.existing != tt.wantSubmissions {
+					t.Errorf("submissions = %d, want %d", s.submissions-tt.existing, tt.wantSubmissions)
+				}
+			})
+		}
+	}
+}
+
+func TestMaybeSubmitted(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", timeoutError{}, true},
+		{"connection lost", io.ErrUnexpectedEOF, true},
+		{"gateway", &HTTPError{Status: http.StatusBadGateway}, true},
+		{"unavailable", &HTTPError{Status: http.StatusServiceUnavailable}, false},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "api.leonardo.ai"}}, false},
+		{"dial timeout", &net.OpError{Op: "dial", Err: timeoutError{}}, false},
+		{"proxy", &net.OpError{Op: "proxyconnect", Err: errors.New("connection refused")}, false},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("leonardo: couldn't POST graphql: %w", tt.err)
+		if got := maybeSubmitted(err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -10,63 +10,110 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/igolaizola/leonai/pkg/ratelimit"
-	"github.com/igolaizola/leonai/pkg/session"
+)
+
+// Default base URLs.
+const (
+	DefaultAppURL  = "https://app.leonardo.ai/"
+	DefaultAPIURL  = "https://api.leonardo.ai/v1/"
+	DefaultRESTURL = "https://cloud.leonardo.ai/api/rest/v1/"
 )
 
 type Client struct {
-	client          *http.Client
-	debug           bool
-	ratelimit       ratelimit.Lock
-	token           string
-	tokenExpiration time.Time
-	cookieStore     CookieStore
-	userID          string
+	client           *http.Client
+	appURL           string
+	apiURL           string
+	restURL          string
+	debug            bool
+	ratelimit        ratelimit.Lock
+	auth             Authenticator
+	middlewares      []Middleware
+	retry            *RetryPolicy
+	maxUploadSize    int64
+	onUploadProgress func(sent, total int64)
+	onEvent          func(Event)
+	uploadCache      UploadCache
+	debugDir         string
+	debugRetention   int
+	debugLock        sync.Mutex
+	backendName      string
+	backend          backend
+	userID           string
+	pollInterval     time.Duration
+	subscriptions    bool
+	poller           *poller
+	submitLock       sync.Mutex
+	submitLocks      map[string]*submitLock
 }
 
 type Config struct {
-	Wait        time.Duration
-	Debug       bool
-	Client      *http.Client
-	CookieStore CookieStore
-}
-
-type cookieStore struct {
-	path string
-}
-
-func (c *cookieStore) GetCookie(ctx context.Context) (string, error) {
-	b, err := os.ReadFile(c.path)
-	if err != nil {
-		return "", fmt.Errorf("leonardo: couldn't read cookie: %w", err)
-	}
-	return string(b), nil
-}
-
-func (c *cookieStore) SetCookie(ctx context.Context, cookie string) error {
-	if err := os.WriteFile(c.path, []byte(cookie), 0644); err != nil {
-		return fmt.Errorf("leonardo: couldn't write cookie: %w", err)
-	}
-	return nil
-}
-
-func NewCookieStore(path string) CookieStore {
-	return &cookieStore{
-		path: path,
-	}
-}
-
-type CookieStore interface {
-	GetCookie(context.Context) (string, error)
-	SetCookie(context.Context, string) error
+	Wait  time.Duration
+	Debug bool
+	// DebugDir is the directory where failed requests are dumped, with
+	// credentials redacted. If empty, nothing is dumped.
+	DebugDir string
+	// DebugRetention is the number of dumps kept in DebugDir. Zero or
+	// negative values use DefaultDebugRetention.
+	DebugRetention int
+	Client         *http.Client
+	// Authenticator provides the credentials. If nil, the browser session
+	// cookie from CookieStore is used.
+	Authenticator Authenticator
+	CookieStore   CookieStore
+	// AppURL is the base URL of the web app, used for the session and its
+	// cookies. Defaults to DefaultAppURL.
+	AppURL string
+	// APIURL is the base URL of the GraphQL API. Defaults to DefaultAPIURL.
+	APIURL string
+	// RESTURL is the base URL of the official REST API. Defaults to
+	// DefaultRESTURL.
+	RESTURL string
+	// MaxUploadSize is the maximum size of an uploaded image in bytes.
+	// Defaults to DefaultMaxUploadSize.
+	MaxUploadSize int64
+	// OnUploadProgress is called while an image is uploaded with the bytes
+	// of the file sent so far and its size.
+	OnUploadProgress func(sent, total int64)
+	// UploadCache reuses the init images of files uploaded before with the
+	// same content. If nil, files are always uploaded.
+	UploadCache UploadCache
+	// OnEvent is called with the progress of uploads, generations and
+	// retries. It may be called from different goroutines.
+	OnEvent func(Event)
+	// Retry is the policy used to retry failed requests. Defaults to
+	// DefaultRetryPolicy.
+	Retry *RetryPolicy
+	// Middlewares wrap every request sent, including retries. The first
+	// middleware is the outermost one.
+	Middlewares []Middleware
+	// Backend is the API used for operations: BackendGraphQL or BackendREST.
+	// If empty, BackendREST is used with API key authentication and
+	// BackendGraphQL otherwise.
+	Backend string
+	// Subscriptions receives status changes of the generations being waited
+	// over a GraphQL subscription instead of polling. Polling is used if the
+	// subscription fails. Only supported by BackendGraphQL.
+	Subscriptions bool
+	// AutoRefresh starts a background refresher on Start that renews the
+	// access token before it expires and reports when the session cookie is
+	// about to expire. Only used with cookie authentication.
+	AutoRefresh bool
+	// ReloginWarning is how long before the session cookie expires the
+	// refresher reports that a new login is needed. Defaults to 24 hours.
+	ReloginWarning time.Duration
+	// OnAuthEvent is called with the events of the refresher. If nil, they
+	// are logged.
+	OnAuthEvent func(AuthEvent)
 }
 
 func New(cfg *Config) *Client {
@@ -74,91 +121,97 @@ func New(cfg *Config) *Client {
 	if wait == 0 {
 		wait = 1 * time.Second
 	}
+	maxUploadSize := cfg.MaxUploadSize
+	if maxUploadSize == 0 {
+		maxUploadSize = DefaultMaxUploadSize
+	}
+	debugRetention := cfg.DebugRetention
+	if debugRetention <= 0 {
+		debugRetention = DefaultDebugRetention
+	}
+	retry := DefaultRetryPolicy()
+	if cfg.Retry != nil {
+		retry = cfg.Retry.withDefaults(retry)
+	}
 	client := cfg.Client
 	if client == nil {
 		client = &http.Client{
 			Timeout: 2 * time.Minute,
 		}
 	}
-	return &Client{
-		client:      client,
-		ratelimit:   ratelimit.New(wait),
-		debug:       cfg.Debug,
-		cookieStore: cfg.CookieStore,
+	c := &Client{
+		client:           client,
+		appURL:           baseURL(cfg.AppURL, DefaultAppURL),
+		apiURL:           baseURL(cfg.APIURL, DefaultAPIURL),
+		restURL:          baseURL(cfg.RESTURL, DefaultRESTURL),
+		ratelimit:        ratelimit.New(wait),
+		debug:            cfg.Debug,
+		auth:             cfg.Authenticator,
+		middlewares:      cfg.Middlewares,
+		retry:            retry,
+		maxUploadSize:    maxUploadSize,
+		onUploadProgress: cfg.OnUploadProgress,
+		onEvent:          cfg.OnEvent,
+		uploadCache:      cfg.UploadCache,
+		debugDir:         cfg.DebugDir,
+		debugRetention:   debugRetention,
+		backendName:      cfg.Backend,
+		pollInterval:     5 * time.Second,
+		subscriptions:    cfg.Subscriptions,
+	}
+	if c.auth == nil {
+		c.auth = newCookieAuth(c, cfg)
+	}
+	c.backend = newBackend(c, cfg.Backend)
+	c.poller = newPoller(c)
+	return c
+}
+
+// baseURL returns the URL or the default one if empty, always ending with a
+// slash so paths can be appended.
+func baseURL(u, def string) string {
+	if u == "" {
+		return def
+	}
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
 	}
+	return u
 }
 
 func (c *Client) Start(ctx context.Context) error {
-	// Get cookie
-	cookie, err := c.cookieStore.GetCookie(ctx)
-	if err != nil {
-		return err
-	}
-	if cookie == "" {
-		return fmt.Errorf("leonardo: cookie is empty")
+	if c.backend == nil {
+		return fmt.Errorf("leonardo: unknown backend %q", c.backendName)
 	}
-	if err := session.SetCookies(c.client, "https://app.leonardo.ai", cookie, nil); err != nil {
-		return fmt.Errorf("leonardo: couldn't set cookie: %w", err)
+	for _, u := range []string{c.appURL, c.apiURL, c.restURL} {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("leonardo: invalid base url %q: %w", u, err)
+		}
+		if parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("leonardo: invalid base url %q", u)
+		}
 	}
-
-	// Authenticate
-	if err := c.Auth(ctx); err != nil {
+	if err := c.auth.Start(ctx, c); err != nil {
 		return err
 	}
-
-	// Get user id
-	cls, err := toClaims(c.token)
+	creds, err := c.auth.Credentials(ctx)
 	if err != nil {
 		return err
 	}
-	userID, err := c.user(ctx, cls.Sub)
-	if err != nil {
-		return err
-	}
-	if userID != cls.HasuraClaims.XHasuraUserID {
-		return fmt.Errorf("leonardo: user id mismatch: %s != %s", userID, cls.HasuraClaims.XHasuraUserID)
-	}
-	c.userID = userID
-
+	c.userID = creds.UserID
 	return nil
 }
 
+// Auth makes sure the client has valid credentials, renewing them if needed.
 func (c *Client) Auth(ctx context.Context) error {
-	if c.token != "" && time.Now().Before(c.tokenExpiration) {
-		return nil
-	}
-	token, expiration, err := c.session(ctx)
-	if err != nil {
-		return err
-	}
-	c.token = token
-	// Set token expiration to 90% of the actual expiration
-	c.tokenExpiration = time.Now().Add(expiration.Sub(time.Now().UTC()) * 90 / 100).UTC()
-	return nil
+	_, err := c.auth.Credentials(ctx)
+	return err
 }
 
 func (c *Client) Stop(ctx context.Context) error {
-	cookie, err := session.GetCookies(c.client, "https://app.leonardo.ai")
-	if err != nil {
-		return fmt.Errorf("leonardo: couldn't get cookie: %w", err)
-	}
-	if err := c.cookieStore.SetCookie(ctx, cookie); err != nil {
-		return err
-	}
-	return nil
-}
-
-type sessionResponse struct {
-	User struct {
-		Name  string `json:"name"`
-		Email string `json:"email"`
-		Sub   string `json:"sub"`
-	} `json:"user"`
-	Expires             string `json:"expires"`
-	AccessToken         string `json:"accessToken"`
-	AccessTokenIssuedAt int    `json:"accessTokenIssuedAt"`
-	AccessTokenExpiry   int    `json:"accessTokenExpiry"`
-	ServerTimestamp     int    `json:"serverTimestamp"`
+	c.poller.stop()
+	return c.auth.Stop(ctx)
 }
 
 type claims struct {
@@ -199,18 +252,6 @@ func toClaims(token string) (*claims, error) {
 	return &c, nil
 }
 
-func (c *Client) session(ctx context.Context) (string, time.Time, error) {
-	var resp sessionResponse
-	if _, err := c.do(ctx, "GET", "api/auth/session", nil, &resp); err != nil {
-		return "", time.Time{}, fmt.Errorf("leonardo: couldn't get session: %w", err)
-	}
-	if resp.AccessToken == "" {
-		return "", time.Time{}, errors.New("leonardo: empty access token")
-	}
-	expiration := time.Unix(int64(resp.AccessTokenExpiry), 0)
-	return resp.AccessToken, expiration, nil
-}
-
 type graphqlRequest struct {
 	OperationName string         `json:"operationName"`
 	Variables     map[string]any `json:"variables"`
@@ -267,11 +308,11 @@ func (c *Client) user(ctx context.Context, sub string) (string, error) {
 	}
 
 	var resp userResponse
-	if _, err := c.do(ctx, "POST", "graphql", req, &resp); err != nil {
+	if _, err := c.do(ctx, req.OperationName, "POST", "graphql", req, &resp); err != nil {
 		return "", err
 	}
 	if len(resp.Data.Users) == 0 {
-		return "", errors.New("leonardo: no users found")
+		return "", fmt.Errorf("leonardo: no users found: %w", ErrNotFound)
 	}
 	if resp.Data.Users[0].ID == "" {
 		return "", errors.New("leonardo: empty user id")
@@ -279,17 +320,6 @@ func (c *Client) user(ctx context.Context, sub string) (string, error) {
 	return resp.Data.Users[0].ID, nil
 }
 
-type createUploadResponse struct {
-	Data struct {
-		UploadInitImage struct {
-			ID     string `json:"id"`
-			Fields string `json:"fields"`
-			Key    string `json:"key"`
-			URL    string `json:"url"`
-		} `json:"uploadInitImage"`
-	} `json:"data"`
-}
-
 type uploadFields struct {
 	ContentType string `json:"Content-Type"`
 	Bucket      string `json:"bucket"`
@@ -334,51 +364,36 @@ func (c *Client) Upload(ctx context.Context, path string) (string, error) {
 		return "", fmt.Errorf("leonardo: unsupported file extension: %s", ext)
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(path); err != nil {
+	// Check if file exists and isn't too big
+	info, err := os.Stat(path)
+	if err != nil {
 		return "", fmt.Errorf("leonardo: couldn't stat file: %w", err)
 	}
-
-	req := &graphqlRequest{
-		OperationName: "CreateUploadInitImage",
-		Variables: map[string]any{
-			"arg1": map[string]any{
-				"fileType":  fileType,
-				"extension": ext,
-			},
-		},
-		Query: uploadQuery,
-	}
-
-	var resp createUploadResponse
-	if _, err := c.do(ctx, "POST", "graphql", req, &resp); err != nil {
-		return "", err
-	}
-
-	u := resp.Data.UploadInitImage.URL
-	if u == "" {
-		return "", fmt.Errorf("leonardo: couldn't get upload url")
+	if info.Size() > c.maxUploadSize {
+		return "", fmt.Errorf("leonardo: file %s is %d bytes, the maximum is %d", path, info.Size(), c.maxUploadSize)
 	}
 
-	var fields uploadFields
-	if err := json.Unmarshal([]byte(resp.Data.UploadInitImage.Fields), &fields); err != nil {
-		return "", fmt.Errorf("leonardo: couldn't unmarshal fields: %w", err)
-	}
-	if fields.Key == "" {
-		return "", fmt.Errorf("leonardo: couldn't get key")
+	// Reuse the image if the same content was already uploaded
+	var cacheKey string
+	if c.uploadCache != nil {
+		if cacheKey, err = c.uploadKey(path); err != nil {
+			return "", err
+		}
+		if id := c.cachedUpload(ctx, cacheKey); id != "" {
+			c.log("leonardo: reusing init image %s for %s", id, path)
+			c.emit(Event{Type: EventUploadDone, Path: path, Total: info.Size(), ImageID: id, Cached: true})
+			return id, nil
+		}
 	}
 
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
-	if err := writer.SetBoundary(fmt.Sprintf("----WebKitFormBoundary%s", webkitID(16))); err != nil {
-		return "", fmt.Errorf("leonardo: couldn't set boundary: %w", err)
+	target, err := c.backend.createUpload(ctx, fileType, ext)
+	if err != nil {
+		return "", err
 	}
+	fields := target.fields
 
 	// Add fields
-	kvs := []struct {
-		key   string
-		value string
-	}{
+	kvs := []formField{
 		{key: "Content-Type", value: fields.ContentType},
 		{key: "bucket", value: fields.Bucket},
 		{key: "X-Amz-Algorithm", value: fields.Algorithm},
@@ -389,283 +404,283 @@ func (c *Client) Upload(ctx context.Context, path string) (string, error) {
 		{key: "Policy", value: fields.Policy},
 		{key: "X-Amz-Signature", value: fields.Signature},
 	}
-	for _, kv := range kvs {
-		if err := writer.WriteField(kv.key, kv.value); err != nil {
-			return "", fmt.Errorf("leonardo: couldn't write field %s: %w", kv.key, err)
+	boundary := fmt.Sprintf("----WebKitFormBoundary%s", webkitID(16))
+	progress := func(sent, total int64) {
+		if c.onUploadProgress != nil {
+			c.onUploadProgress(sent, total)
 		}
+		c.emit(Event{Type: EventUploadProgress, Path: path, Sent: sent, Total: total})
 	}
-
-	part, err := writer.CreateFormFile("file", filepath.Base(path))
-	if err != nil {
-		return "", fmt.Errorf("leonardo: couldn't create form file: %w", err)
-	}
-
-	// Open file
-	reader, err := os.Open(path)
+	f, err := newForm(path, info.Size(), boundary, kvs, progress)
 	if err != nil {
-		return "", fmt.Errorf("leonardo: couldn't open file: %w", err)
-	}
-	defer reader.Close()
-
-	// Copy file to part
-	if _, err := io.Copy(part, reader); err != nil {
-		return "", fmt.Errorf("leonardo: couldn't copy file to part: %w", err)
-	}
-
-	// Close writer
-	if err := writer.Close(); err != nil {
-		return "", fmt.Errorf("leonardo: couldn't close writer: %w", err)
+		return "", err
 	}
 
 	// Upload file
-	f := &form{
-		writer: writer,
-		data:   &buf,
-	}
-	if _, err := c.do(ctx, "POST", u, f, nil); err != nil {
+	c.emit(Event{Type: EventUploadStarted, Path: path, Total: info.Size()})
+	if _, err := c.do(ctx, "UploadInitImage", "POST", target.url, f, nil); err != nil {
 		return "", err
 	}
-	return resp.Data.UploadInitImage.ID, nil
-}
-
-type createGenerationResponse struct {
-	Data struct {
-		MotionSVDGenerationJob struct {
-			APICreditCost int    `json:"apiCreditCost"`
-			GenerationID  string `json:"generationId"`
-		} `json:"motionSvdGenerationJob"`
-	} `json:"data"`
+	c.emit(Event{Type: EventUploadDone, Path: path, Total: info.Size(), ImageID: target.id})
+	if c.uploadCache != nil {
+		if err := c.uploadCache.SetUpload(ctx, cacheKey, target.id); err != nil {
+			log.Printf("leonardo: %v\n", err)
+		}
+	}
+	return target.id, nil
 }
 
-type feedResponse struct {
-	Data struct {
-		Generations []generation `json:"generations"`
-	} `json:"data"`
+// uploadTarget is a presigned location to upload an init image to.
+type uploadTarget struct {
+	id     string
+	url    string
+	fields uploadFields
 }
 
-type generation struct {
-	Alchemy             any    `json:"alchemy"`
-	ContrastRatio       any    `json:"contrastRatio"`
-	HighResolution      any    `json:"highResolution"`
-	GuidanceScale       any    `json:"guidanceScale"`
-	InferenceSteps      any    `json:"inferenceSteps"`
-	ModelId             any    `json:"modelId"`
-	Scheduler           any    `json:"scheduler"`
-	CoreModel           string `json:"coreModel"`
-	SdVersion           any    `json:"sdVersion"`
-	Prompt              string `json:"prompt"`
-	NegativePrompt      any    `json:"negativePrompt"`
-	ID                  string `json:"id"`
-	Status              string `json:"status"`
-	Quantity            int    `json:"quantity"`
-	CreatedAt           string `json:"createdAt"`
-	ImageHeight         int    `json:"imageHeight"`
-	ImageWidth          int    `json:"imageWidth"`
-	PresetStyle         any    `json:"presetStyle"`
-	Public              bool   `json:"public"`
-	Seed                int64  `json:"seed"`
-	Tiling              any    `json:"tiling"`
-	InitStrength        any    `json:"initStrength"`
-	ImageToImage        bool   `json:"imageToImage"`
-	HighContrast        bool   `json:"highContrast"`
-	PromptMagic         bool   `json:"promptMagic"`
-	PromptMagicVersion  any    `json:"promptMagicVersion"`
-	PromptMagicStrength any    `json:"promptMagicStrength"`
-	ImagePromptStrength any    `json:"imagePromptStrength"`
-	ExpandedDomain      any    `json:"expandedDomain"`
-	Motion              bool   `json:"motion"`
-	PhotoReal           any    `json:"photoReal"`
-	PhotoRealStrength   any    `json:"photoRealStrength"`
-	Nsfw                bool   `json:"nsfw"`
-	User                struct {
-		Username string `json:"username"`
-		ID       string `json:"id"`
-		Typename string `json:"__typename"`
-	} `json:"user"`
-	CustomModel any `json:"custom_model"`
-	InitImage   struct {
-		ID       string `json:"id"`
-		URL      string `json:"url"`
-		Typename string `json:"__typename"`
-	} `json:"init_image"`
-	GeneratedImages []struct {
-		ID                              string        `json:"id"`
-		URL                             string        `json:"url"`
-		MotionGIFURL                    *string       `json:"motionGIFURL"`
-		MotionMP4URL                    *string       `json:"motionMP4URL"`
-		LikeCount                       int           `json:"likeCount"`
-		Nsfw                            bool          `json:"nsfw"`
-		GeneratedImageVariationGenerics []interface{} `json:"generated_image_variation_generics"`
-		Typename                        string        `json:"__typename"`
-	} `json:"generated_images"`
-	GenerationElements    []interface{} `json:"generation_elements"`
-	GenerationControlnets []interface{} `json:"generation_controlnets"`
-	Typename              string        `json:"__typename"`
-}
-
-type statusResponse struct {
-	Data struct {
-		Generations []generationStatus `json:"generations"`
-	} `json:"data"`
+// newUploadTarget parses the presigned upload returned by the API, fields is
+// a JSON object encoded as a string. Relative upload URLs are resolved
+// against the API base URL.
+func (c *Client) newUploadTarget(id, u, fields string) (*uploadTarget, error) {
+	if u == "" {
+		return nil, fmt.Errorf("leonardo: couldn't get upload url")
+	}
+	ref, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't parse upload url: %w", err)
+	}
+	if !ref.IsAbs() {
+		base, err := url.Parse(c.apiURL)
+		if err != nil {
+			return nil, fmt.Errorf("leonardo: couldn't parse api url: %w", err)
+		}
+		u = base.ResolveReference(ref).String()
+	}
+	t := &uploadTarget{
+		id:  id,
+		url: u,
+	}
+	if err := json.Unmarshal([]byte(fields), &t.fields); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't unmarshal fields: %w", err)
+	}
+	if t.fields.Key == "" {
+		return nil, fmt.Errorf("leonardo: couldn't get key")
+	}
+	return t, nil
 }
 
-type generationStatus struct {
-	ID       string `json:"id"`
-	Status   string `json:"status"`
-	Typename string `json:"__typename"`
+// CreateMotion submits a motion generation of an uploaded image, waits for it
+// and returns the generated image id and the video url.
+func (c *Client) CreateMotion(ctx context.Context, id string, motionStrength int) (string, string, error) {
+	generationID, err := c.SubmitMotion(ctx, id, motionStrength)
+	if err != nil {
+		return "", "", err
+	}
+	gen, err := c.WaitGeneration(ctx, generationID)
+	if err != nil {
+		return "", "", err
+	}
+	video, err := gen.Video()
+	if err != nil {
+		return "", "", err
+	}
+	return video.ID, video.MotionMP4URL, nil
 }
 
-func (c *Client) CreateMotion(ctx context.Context, id string, motionStrength int) (string, string, error) {
+// SubmitMotion submits a motion generation of an uploaded image and returns
+// the generation id without waiting for it.
+func (c *Client) SubmitMotion(ctx context.Context, imageID string, motionStrength int) (string, error) {
 	// Authenticate if necessary
 	if err := c.Auth(ctx); err != nil {
-		return "", "", err
+		return "", err
 	}
 
 	if motionStrength == 0 {
 		motionStrength = 5
 	}
-	userID := c.userID
-	if userID == "" {
-		return "", "", errors.New("leonardo: empty user id")
+	generationID, cost, err := c.backend.createMotion(ctx, imageID, motionStrength)
+	if err != nil {
+		return "", fmt.Errorf("leonardo: couldn't create motion: %w", moderationError(err))
 	}
-
-	createReq := &graphqlRequest{
-		OperationName: "CreateMotionSvdGenerationJob",
-		Variables: map[string]any{
-			"arg1": map[string]any{
-				"imageId":        id,
-				"isPublic":       false,
-				"isInitImage":    true,
-				"isVariation":    false,
-				"motionStrength": motionStrength,
-			},
-		},
-		Query: createQuery,
+	if generationID == "" {
+		return "", fmt.Errorf("leonardo: couldn't get generation id")
 	}
+	c.emit(Event{Type: EventJobSubmitted, ImageID: imageID, GenerationID: generationID, Cost: cost})
+	return generationID, nil
+}
 
-	var createResp createGenerationResponse
-	if _, err := c.do(ctx, "POST", "graphql", createReq, &createResp); err != nil {
-		return "", "", fmt.Errorf("leonardo: couldn't create motion: %w", err)
+// WaitGeneration waits for a generation until it is complete and returns it.
+// If the generation fails, the error is a *ModerationError if it was flagged
+// as NSFW and an *ErrGenerationFailed otherwise.
+func (c *Client) WaitGeneration(ctx context.Context, id string) (*Generation, error) {
+	gens, err := c.WaitGenerations(ctx, []string{id})
+	if err != nil {
+		return nil, err
 	}
-	generationID := createResp.Data.MotionSVDGenerationJob.GenerationID
-	if generationID == "" {
-		return "", "", fmt.Errorf("leonardo: couldn't get generation id")
+	gen := gens[0]
+	if gen.Status == StatusFailed {
+		return nil, gen.failure()
 	}
+	return gen, nil
+}
 
-	statusReq := &graphqlRequest{
-		OperationName: "GetAIGenerationFeedStatuses",
-		Variables: map[string]any{
-			"where": map[string]any{
-				"status": map[string]any{
-					"_in": []string{"COMPLETE", "FAILED"},
-				},
-				"id": map[string]any{
-					"_in": []string{generationID},
-				},
-			},
-		},
-		Query: statusQuery,
+// GetStatuses returns the status of each generation, in the same order as
+// ids. Generations that aren't found have an empty status.
+func (c *Client) GetStatuses(ctx context.Context, ids []string) ([]string, error) {
+	// Authenticate if necessary
+	if err := c.Auth(ctx); err != nil {
+		return nil, err
 	}
+	gens, err := c.backend.getStatuses(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]string{}
+	for _, g := range gens {
+		byID[g.ID] = g.Status
+	}
+	statuses := make([]string, len(ids))
+	for i, id := range ids {
+		statuses[i] = byID[id]
+	}
+	return statuses, nil
+}
 
-	var last []byte
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("leonardo: context done, last response:", string(last))
-			return "", "", ctx.Err()
-		case <-time.After(5 * time.Second):
-		}
-		var statusResp statusResponse
-		b, err := c.do(ctx, "POST", "graphql", statusReq, &statusResp)
-		if err != nil {
-			return "", "", fmt.Errorf("leonardo: couldn't get status: %w", err)
-		}
-		last = b
-		if len(statusResp.Data.Generations) == 0 {
-			continue
+// WaitGenerations waits for the generations until all of them are complete
+// or failed, and returns them in the same order as ids. Failed generations
+// are returned with their status instead of an error.
+// Status changes are received with a subscription if enabled, falling back
+// to the poller shared by all the waits of the client.
+func (c *Client) WaitGenerations(ctx context.Context, ids []string) ([]*Generation, error) {
+	// Authenticate if necessary
+	if err := c.Auth(ctx); err != nil {
+		return nil, err
+	}
+	gens := make([]*Generation, len(ids))
+	if w, ok := c.backend.(statusWatcher); ok && c.subscriptions {
+		last := map[string]string{}
+		misses := map[string]int{}
+		err := w.watchStatuses(ctx, ids, func(statuses []Generation) (bool, error) {
+			found := map[string]string{}
+			for _, s := range statuses {
+				found[s.ID] = s.Status
+				if last[s.ID] != s.Status {
+					last[s.ID] = s.Status
+					c.emit(Event{Type: EventStatusChanged, GenerationID: s.ID, Status: s.Status})
+				}
+			}
+			for i, id := range ids {
+				if gens[i] != nil {
+					continue
+				}
+				// A generation that was just created may not be listed yet
+				if _, ok := found[id]; !ok {
+					misses[id]++
+					if misses[id] < notFoundAttempts {
+						continue
+					}
+				} else {
+					delete(misses, id)
+				}
+				if err := c.settle(ctx, ids, gens, id, found[id]); err != nil {
+					return false, err
+				}
+			}
+			return done(gens), nil
+		})
+		switch {
+		case err == nil:
+			return gens, nil
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("leonardo: pending generations: %w", ctx.Err())
+		case errors.Is(err, ErrNotFound):
+			return nil, err
 		}
-		s := statusResp.Data.Generations[0]
-		if s.Status != "COMPLETE" {
-			return "", "", fmt.Errorf("leonardo: status generation %s", s.Status)
+		c.log("leonardo: subscription failed, polling instead: %v", err)
+	}
+	// Wait for the pending generations with the shared poller
+	var pending []string
+	for i, id := range ids {
+		if gens[i] == nil {
+			pending = append(pending, id)
 		}
-		break
 	}
-
-	feedReq := &graphqlRequest{
-		OperationName: "GetAIGenerationFeed",
-		Variables: map[string]any{
-			"where": map[string]any{
-				"userId": map[string]any{
-					"_eq": userID,
-				},
-				"teamId": map[string]any{
-					"_is_null": true,
-				},
-				"canvasRequest": map[string]any{
-					"_eq": false,
-				},
-				"universalUpscaler": map[string]any{
-					"_is_null": true,
-				},
-				"isStoryboard": map[string]any{
-					"_eq": false,
-				},
-			},
-			"offset": 0,
-			"limit":  10,
-		},
-		Query: feedQuery,
+	results := make([]<-chan pollResult, len(pending))
+	for i, id := range pending {
+		ch, cancel := c.poller.watch(id)
+		defer cancel()
+		results[i] = ch
 	}
-
-	wait := 1 * time.Second
-	var gen *generation
-	for {
+	for i, ch := range results {
+		var r pollResult
 		select {
 		case <-ctx.Done():
-			return "", "", fmt.Errorf("leonardo: pending generation: %w", ctx.Err())
-		case <-time.After(wait):
+			return nil, fmt.Errorf("leonardo: pending generations: %w", ctx.Err())
+		case r = <-ch:
 		}
-		wait = 5 * time.Second
-		var feedResp feedResponse
-		if _, err := c.do(ctx, "POST", "graphql", feedReq, &feedResp); err != nil {
-			return "", "", fmt.Errorf("leonardo: couldn't get feed: %w", err)
+		if r.err != nil {
+			return nil, fmt.Errorf("leonardo: couldn't get status: %w", r.err)
 		}
-		if len(feedResp.Data.Generations) == 0 {
-			return "", "", errors.New("leonardo: no generations found")
+		if err := c.settle(ctx, ids, gens, pending[i], r.status); err != nil {
+			return nil, err
 		}
-		var candidate *generation
-		for _, g := range feedResp.Data.Generations {
-			if g.ID != generationID {
-				continue
-			}
-			candidate = &g
-			break
+	}
+	return gens, nil
+}
+
+// settle stores the generation with the given id and status in gens if it
+// is complete or failed. Complete generations are fetched to get their
+// assets and failed ones to get their moderation flags.
+func (c *Client) settle(ctx context.Context, ids []string, gens []*Generation, id, status string) error {
+	var gen *Generation
+	switch status {
+	case "":
+		return fmt.Errorf("leonardo: couldn't find generation %s: %w", id, ErrNotFound)
+	case StatusComplete:
+		var err error
+		if gen, err = c.backend.getGeneration(ctx, id, status); err != nil {
+			return fmt.Errorf("leonardo: couldn't get generation: %w", err)
 		}
-		if candidate == nil {
-			return "", "", fmt.Errorf("leonardo: couldn't find generation %s", generationID)
+		for _, img := range gen.Images {
+			u := img.MotionMP4URL
+			if u == "" {
+				u = img.URL
+			}
+			if u != "" {
+				c.emit(Event{Type: EventAssetReady, GenerationID: id, AssetID: img.ID, URL: u})
+			}
 		}
-		switch candidate.Status {
-		case "PENDING":
-			continue
-		case "COMPLETE":
-		default:
-			return "", "", fmt.Errorf("leonardo: feed generation %s", candidate.Status)
+	case StatusFailed:
+		var err error
+		if gen, err = c.backend.getGeneration(ctx, id, status); err != nil {
+			return fmt.Errorf("leonardo: couldn't get generation: %w", err)
 		}
-		gen = candidate
-		break
+	default:
+		return nil
 	}
-	if len(gen.GeneratedImages) == 0 {
-		return "", "", fmt.Errorf("leonardo: couldn't get generated images")
+	for i := range ids {
+		if ids[i] == id {
+			gens[i] = gen
+		}
 	}
-	u := gen.GeneratedImages[0].MotionMP4URL
-	if u == nil || *u == "" {
-		return "", "", fmt.Errorf("leonardo: empty motion mp4 url")
+	return nil
+}
+
+func done(gens []*Generation) bool {
+	for _, g := range gens {
+		if g == nil {
+			return false
+		}
 	}
-	id = gen.GeneratedImages[0].ID
-	if id == "" {
-		return "", "", fmt.Errorf("leonardo: empty generated image id")
+	return true
+}
+
+// GetGeneration returns the current state of a generation.
+func (c *Client) GetGeneration(ctx context.Context, id string) (*Generation, error) {
+	// Authenticate if necessary
+	if err := c.Auth(ctx); err != nil {
+		return nil, err
 	}
-	return id, *u, nil
+	return c.backend.getGeneration(ctx, id, "")
 }
 
 func (c *Client) log(format string, args ...interface{}) {
@@ -675,61 +690,81 @@ func (c *Client) log(format string, args ...interface{}) {
 	}
 }
 
-var backoff = []time.Duration{
-	30 * time.Second,
-	1 * time.Minute,
-	2 * time.Minute,
+// do sends a request, retrying on temporary errors. op is the logical name of
+// the operation, passed to the middlewares.
+func (c *Client) do(ctx context.Context, op, method, path string, in, out any) ([]byte, error) {
+	return c.doGuarded(ctx, op, method, path, in, out, nil)
 }
 
-func (c *Client) do(ctx context.Context, method, path string, in, out any) ([]byte, error) {
-	maxAttempts := 3
-	attempts := 0
+// doGuarded is like do, but if an attempt fails in a way the request may
+// have reached the server, guard is called before retrying and the request
+// isn't sent again if guard reports it was already submitted.
+func (c *Client) doGuarded(ctx context.Context, op, method, path string, in, out any, guard submitGuard) ([]byte, error) {
+	policy := c.retry.operation(op)
 	var err error
-	for {
+	var refreshed bool
+	for attempts := 1; ; attempts++ {
 		if err != nil {
-			log.Println("retrying...", err)
+			if guard != nil && maybeSubmitted(err) {
+				submitted, gErr := guard(ctx)
+				if gErr != nil {
+					return nil, fmt.Errorf("leonardo: couldn't check if %s was submitted after %v: %w", op, err, gErr)
+				}
+				if submitted {
+					return nil, nil
+				}
+			}
 		}
 		var b []byte
-		b, err = c.doAttempt(ctx, method, path, in, out)
+		var token string
+		b, token, err = c.doAttempt(ctx, op, method, path, in, out)
 		if err == nil {
 			return b, nil
 		}
-		// Increase attempts and check if we should stop
-		attempts++
-		if attempts >= maxAttempts {
+
+		// If the JWT is invalid re-authenticate and retry once, without
+		// counting it against the retry policy
+		var errAPI *APIError
+		if errors.As(err, &errAPI) && errAPI.Code == invalidJWTCode && !refreshed {
+			refreshed = true
+			if rErr := c.auth.Refresh(ctx, token); rErr != nil {
+				return nil, rErr
+			}
+			c.emit(Event{Type: EventRetrying, Operation: op, Attempt: attempts + 1, Err: err})
+			attempts--
+			continue
+		}
+
+		// Check if we should stop
+		if attempts >= policy.MaxAttempts {
 			return nil, err
 		}
 		// If the error is temporary retry
 		var netErr net.Error
 		if errors.As(err, &netErr) && netErr.Timeout() {
+			c.emit(Event{Type: EventRetrying, Operation: op, Attempt: attempts + 1, Err: err})
 			continue
 		}
 
 		// Check if we should retry after waiting
 		var retry bool
+		var retryAfter time.Duration
 
 		// Check status code
-		var errStatus errStatusCode
+		var errStatus *HTTPError
 		if errors.As(err, &errStatus) {
-			switch int(errStatus) {
-			case http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusTooManyRequests:
-				// Retry on these status codes
-				retry = true
-			default:
+			if !policy.retryStatus(errStatus.Status) {
 				return nil, err
 			}
+			retry = true
+			retryAfter = errStatus.RetryAfter
 		}
 
 		// Check API error
-		var errAPI errAPI
-		if errors.As(err, &errAPI) {
-			if errAPI.code == invalidJWTCode {
-				// If the JWT is invalid we should re-authenticate
-				if err := c.Auth(ctx); err != nil {
-					return nil, err
-				}
+		if !retry && errors.As(err, &errAPI) {
+			if !policy.retryCode(errAPI.Code) {
+				return nil, err
 			}
-			// Retry on any API error
 			retry = true
 		}
 
@@ -737,16 +772,21 @@ func (c *Client) do(ctx context.Context, method, path string, in, out any) ([]by
 			return nil, err
 		}
 
-		// Wait before retrying
-		idx := attempts - 1
-		if idx >= len(backoff) {
-			idx = len(backoff) - 1
+		// Wait before retrying, the server may tell us how long but no
+		// longer than the maximum delay
+		wait := policy.delay(attempts)
+		if retryAfter > 0 {
+			wait = retryAfter
+			if policy.MaxDelay > 0 && wait > policy.MaxDelay {
+				wait = policy.MaxDelay
+			}
 		}
-		wait := backoff[idx]
 		c.log("server seems to be down, waiting %s before retrying\n", wait)
+		c.emit(Event{Type: EventRetrying, Operation: op, Attempt: attempts + 1, Wait: wait, Err: err})
 		t := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
+			t.Stop()
 			return nil, ctx.Err()
 		case <-t.C:
 		}
@@ -762,15 +802,22 @@ type errorResponse struct {
 	} `json:"errors"`
 }
 
-type form struct {
-	writer *multipart.Writer
-	data   *bytes.Buffer
+// apiError returns the errors of a GraphQL response as an *APIError.
+func (r *errorResponse) apiError(op string) *APIError {
+	var msgs []string
+	for _, e := range r.Errors {
+		msgs = append(msgs, e.Message)
+	}
+	return &APIError{
+		Code:      r.Errors[0].Extensions.Code,
+		Message:   strings.Join(msgs, ", "),
+		Operation: op,
+	}
 }
 
-type errStatusCode int
-
-func (e errStatusCode) Error() string {
-	return fmt.Sprintf("%d", e)
+type restErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
 }
 
 // Known error codes
@@ -778,26 +825,23 @@ const (
 	invalidJWTCode = "invalid-jwt"
 )
 
-type errAPI struct {
-	code string
-}
-
-func (e errAPI) Error() string {
-	return e.code
-}
-
-func (c *Client) doAttempt(ctx context.Context, method, path string, in, out any) ([]byte, error) {
+// doAttempt sends a single request. It also returns the bearer token it used,
+// so a rejected token can be told apart from one that was already renewed.
+func (c *Client) doAttempt(ctx context.Context, op, method, path string, in, out any) ([]byte, string, error) {
 	var body []byte
 	var reqBody io.Reader
 	contentType := "application/json"
-	if f, ok := in.(*form); ok {
-		reqBody = f.data
-		contentType = f.writer.FormDataContentType()
+	f, isForm := in.(*form)
+	if isForm {
+		body := f.open()
+		defer body.Close()
+		reqBody = body
+		contentType = f.contentType
 	} else if in != nil {
 		var err error
 		body, err = json.Marshal(in)
 		if err != nil {
-			return nil, fmt.Errorf("leonardo: couldn't marshal request body: %w", err)
+			return nil, "", fmt.Errorf("leonardo: couldn't marshal request body: %w", err)
 		}
 		reqBody = bytes.NewReader(body)
 	}
@@ -808,30 +852,48 @@ func (c *Client) doAttempt(ctx context.Context, method, path string, in, out any
 	c.log("leonardo: do %s %s %s", method, path, logBody)
 
 	// Check if path is absolute
-	u := fmt.Sprintf("https://api.leonardo.ai/v1/%s", path)
+	u := c.apiURL + path
 	if strings.HasPrefix(path, "api") {
-		u = fmt.Sprintf("https://app.leonardo.ai/%s", path)
+		u = c.appURL + path
 	}
 	if strings.HasPrefix(path, "http") {
 		u = path
 	}
 	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("leonardo: couldn't create request: %w", err)
+		return nil, "", fmt.Errorf("leonardo: couldn't create request: %w", err)
 	}
-	c.addHeaders(req, path, contentType)
+	if isForm {
+		req.ContentLength = f.size
+	}
+
+	// Requests to the API are authenticated with a bearer token
+	var token string
+	if !strings.HasPrefix(contentType, "multipart/form-data") && !strings.HasPrefix(path, "api") {
+		creds, err := c.auth.Credentials(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		token = creds.Token
+	}
+	c.addHeaders(req, path, contentType, token)
 
 	unlock := c.ratelimit.Lock(ctx)
 	defer unlock()
 
-	resp, err := c.client.Do(req)
+	start := time.Now()
+	resp, err := c.send(op, req)
 	if err != nil {
-		return nil, fmt.Errorf("leonardo: couldn't %s %s: %w", method, u, err)
+		err = fmt.Errorf("leonardo: couldn't %s %s: %w", method, u, err)
+		c.dump(op, req, body, nil, nil, start, err)
+		return nil, token, err
 	}
 	defer resp.Body.Close()
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("leonardo: couldn't read response body: %w", err)
+		err = fmt.Errorf("leonardo: couldn't read response body: %w", err)
+		c.dump(op, req, body, resp, nil, start, err)
+		return nil, token, err
 	}
 	c.log("leonardo: response %s %s %d %s", method, path, resp.StatusCode, string(respBody))
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -839,52 +901,64 @@ func (c *Client) doAttempt(ctx context.Context, method, path string, in, out any
 		if len(errMessage) > 100 {
 			errMessage = errMessage[:100] + "..."
 		}
-		_ = os.WriteFile(fmt.Sprintf("logs/debug_%s.json", time.Now().Format("20060102_150405")), respBody, 0644)
-		return nil, fmt.Errorf("leonardo: %s %s returned (%s): %w", method, u, errMessage, errStatusCode(resp.StatusCode))
+		errStatus := &HTTPError{
+			Status:     resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+		err := fmt.Errorf("leonardo: %s %s returned (%s): %w", method, u, errMessage, errStatus)
+		// The REST API describes the error in the body
+		var restErr restErrorResponse
+		if jsonErr := json.Unmarshal(respBody, &restErr); jsonErr == nil && restErr.Error != "" {
+			err = fmt.Errorf("%w: %w", &APIError{Code: restErr.Code, Message: restErr.Error, Operation: op}, errStatus)
+		}
+		c.dump(op, req, body, resp, respBody, start, err)
+		return nil, token, err
 	}
 	if out != nil {
 		var errResp errorResponse
 		if err := json.Unmarshal(respBody, &errResp); err == nil && len(errResp.Errors) > 0 {
-			var msgs []string
-			for _, e := range errResp.Errors {
-				msgs = append(msgs, fmt.Sprintf("%s (%s)", e.Message, e.Extensions.Code))
-			}
-			_ = os.WriteFile(fmt.Sprintf("logs/debug_%s.json", time.Now().Format("20060102_150405")), respBody, 0644)
-			return nil, fmt.Errorf("leonardo: %s: %w", strings.Join(msgs, ", "), errAPI{code: errResp.Errors[0].Extensions.Code})
+			err := errResp.apiError(op)
+			c.dump(op, req, body, resp, respBody, start, err)
+			return nil, token, err
 		}
 		if err := json.Unmarshal(respBody, out); err != nil {
-			// Write response body to file for debugging.
-			_ = os.WriteFile(fmt.Sprintf("logs/debug_%s.json", time.Now().Format("20060102_150405")), respBody, 0644)
-			return nil, fmt.Errorf("leonardo: couldn't unmarshal response body (%T): %w", out, err)
+			err = fmt.Errorf("leonardo: couldn't unmarshal response body (%T): %w", out, err)
+			c.dump(op, req, body, resp, respBody, start, err)
+			return nil, token, err
 		}
 	}
-	return respBody, nil
+	return respBody, token, nil
 }
 
-func (c *Client) addHeaders(req *http.Request, path, contentType string) {
+func (c *Client) addHeaders(req *http.Request, path, contentType, token string) {
+	origin := strings.TrimSuffix(c.appURL, "/")
 	switch {
 	case strings.HasPrefix(contentType, "multipart/form-data"):
 		req.Header.Set("Accept", "*")
 		req.Header.Set("Accept-Language", "en-US,en;q=0.9")
 		req.Header.Set("Connection", "keep-alive")
 		req.Header.Set("Content-Type", contentType)
-		req.Header.Set("Origin", "https://app.leonardo.ai")
-		req.Header.Set("Referer", "https://app.leonardo.ai/")
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Referer", c.appURL)
 		req.Header.Set("Sec-Fetch-Dest", "empty")
 		req.Header.Set("Sec-Fetch-Mode", "cors")
 		req.Header.Set("User-Agent", `Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36`)
 		req.Header.Set("sec-ch-ua", `"Not A(Brand";v="99", "Google Chrome";v="121", "Chromium";v="121"`)
 		req.Header.Set("sec-ch-ua-mobile", "?0")
 		req.Header.Set("sec-ch-ua-platform", `"Windows"`)
+	case strings.HasPrefix(path, c.restURL):
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set("content-type", contentType)
 	case strings.HasPrefix(path, "api"):
-		req.Header.Set("Authority", "app.leonardo.ai")
+		req.Header.Set("Authority", hostname(c.appURL))
 		req.Header.Set("Accept", "*/*")
 		req.Header.Set("Accept-Language", "en-US,en;q=0.9")
 		// TODO: Check if this is necessary
 		// req.Header.Set("Baggage", "sentry-environment=production,sentry-release=,sentry-public_key=,sentry-trace_id=")
 		req.Header.Set("Content-Type", contentType)
-		req.Header.Set("Origin", "https://app.leonardo.ai")
-		req.Header.Set("Referer", "https://app.leonardo.ai/")
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Referer", c.appURL)
 		req.Header.Set("sec-ch-ua", `"Not A(Brand";v="99", "Google Chrome";v="121", "Chromium";v="121"`)
 		req.Header.Set("sec-ch-ua-mobile", "?0")
 		req.Header.Set("sec-ch-ua-platform", `"Windows"`)
@@ -895,13 +969,13 @@ func (c *Client) addHeaders(req *http.Request, path, contentType string) {
 		// req.Header.Set("sentry-trace", "")
 		req.Header.Set("user-agent", `Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36`)
 	default:
-		req.Header.Set("authority", "api.leonardo.ai")
+		req.Header.Set("authority", hostname(c.apiURL))
 		req.Header.Set("accept", "*/*")
 		req.Header.Set("accept-language", "en-US,en;q=0.9")
-		req.Header.Set("authorization", fmt.Sprintf("Bearer %s", c.token))
+		req.Header.Set("authorization", fmt.Sprintf("Bearer %s", token))
 		req.Header.Set("content-yype", contentType)
-		req.Header.Set("origin", "https://app.leonardo.ai")
-		req.Header.Set("Referer", "https://app.leonardo.ai/")
+		req.Header.Set("origin", origin)
+		req.Header.Set("Referer", c.appURL)
 		req.Header.Set("sec-fetch-dest", "empty")
 		req.Header.Set("sec-fetch-mode", "cors")
 		req.Header.Set("sec-fetch-site", "same-site")
@@ -911,3 +985,12 @@ func (c *Client) addHeaders(req *http.Request, path, contentType string) {
 		req.Header.Set("sec-ch-ua-platform", `"Windows"`)
 	}
 }
+
+// hostname returns the host of a URL, or an empty string if it is invalid.
+func hostname(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly || windows)
+
+package leonardo
+
+import "os"
+
+// Advisory locks aren't available on this platform.
+
+func lock(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
+
+func readOnly(err error) bool {
+	return false
+}
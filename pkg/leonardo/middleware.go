@@ -0,0 +1,20 @@
+package leonardo
+
+import "net/http"
+
+// Doer sends a request and returns its response.
+type Doer func(req *http.Request) (*http.Response, error)
+
+// Middleware wraps the sending of a request. op is the logical name of the
+// operation, like CreateMotionSvdGenerationJob. Middlewares run inside the
+// retry loop, so they are called once per attempt.
+type Middleware func(op string, next Doer) Doer
+
+// send sends the request through the middleware chain.
+func (c *Client) send(op string, req *http.Request) (*http.Response, error) {
+	next := Doer(c.client.Do)
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		next = c.middlewares[i](op, next)
+	}
+	return next(req)
+}
@@ -0,0 +1,66 @@
+package leonardo
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestMiddlewares(t *testing.T) {
+	var lock sync.Mutex
+	var calls []string
+	record := func(name string) Middleware {
+		return func(op string, next Doer) Doer {
+			return func(req *http.Request) (*http.Response, error) {
+				lock.Lock()
+				calls = append(calls, name+":"+op+":"+req.Header.Get("X-Request-Id"))
+				lock.Unlock()
+				return next(req)
+			}
+		}
+	}
+	// The first attempt of every operation fails before reaching the server
+	failed := map[string]bool{}
+	fault := func(op string, next Doer) Doer {
+		return func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			fail := !failed[op]
+			failed[op] = true
+			lock.Unlock()
+			if fail {
+				return nil, timeoutError{}
+			}
+			return next(req)
+		}
+	}
+	requestID := func(op string, next Doer) Doer {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Request-Id", op)
+			return next(req)
+		}
+	}
+
+	c := newStandInClient(t, &standIn{}, BackendREST, &Config{
+		Middlewares: []Middleware{record("outer"), fault, requestID, record("inner")},
+	})
+	ctx := context.Background()
+	if _, err := c.GetGeneration(ctx, "gen-1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"outer:GetGeneration:",
+		"outer:GetGeneration:",
+		"inner:GetGeneration:GetGeneration",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
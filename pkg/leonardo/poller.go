@@ -0,0 +1,279 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// pollBackoffAfter is how long a generation is polled at the base
+	// interval before polling slows down.
+	pollBackoffAfter = time.Minute
+	// maxPollFactor limits the slowdown to this many base intervals.
+	maxPollFactor = 6
+	// notFoundAttempts is how many consecutive status responses a generation
+	// can be missing from before it is reported as not found, a generation
+	// that was just created may not be listed yet.
+	notFoundAttempts = 3
+	// pollErrorAttempts is how many consecutive status requests can fail
+	// before the error is reported to the waiters.
+	pollErrorAttempts = 5
+)
+
+// poller polls the status of every generation being waited with a single
+// request per tick.
+type poller struct {
+	c       *Client
+	lock    sync.Mutex
+	waiters map[string][]*pollWaiter
+	// statuses is the last status seen of each generation polled.
+	statuses map[string]string
+	// misses is the number of consecutive polls each generation was missing
+	// from.
+	misses map[string]int
+	// failures is the number of consecutive polls that failed.
+	failures int
+	wake     chan struct{}
+	running  bool
+	// cancel cancels the requests of the running poll loop.
+	cancel context.CancelFunc
+}
+
+type pollWaiter struct {
+	ch    chan pollResult
+	since time.Time
+}
+
+// pollResult is the final status of a generation, empty if it wasn't found,
+// or the error of the status request.
+type pollResult struct {
+	status string
+	err    error
+}
+
+func newPoller(c *Client) *poller {
+	return &poller{
+		c:        c,
+		waiters:  map[string][]*pollWaiter{},
+		statuses: map[string]string{},
+		misses:   map[string]int{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// watch registers a waiter for a generation. The channel receives a single
+// result when the generation is complete, failed or not found. cancel must be
+// called if the waiter leaves before receiving it.
+func (p *poller) watch(id string) (<-chan pollResult, func()) {
+	w := &pollWaiter{
+		ch:    make(chan pollResult, 1),
+		since: time.Now(),
+	}
+	p.lock.Lock()
+	p.waiters[id] = append(p.waiters[id], w)
+	if !p.running {
+		var ctx context.Context
+		ctx, p.cancel = context.WithCancel(context.Background())
+		p.running = true
+		go p.run(ctx)
+	}
+	p.lock.Unlock()
+
+	// Wake the poller up, a new generation may need an earlier poll
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	cancel := func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		ws := p.waiters[id]
+		for i := range ws {
+			if ws[i] == w {
+				ws = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+		if len(ws) == 0 {
+			p.remove(id)
+		} else {
+			p.waiters[id] = ws
+		}
+		// Nobody is waiting, stop polling and cancel the request in flight
+		if len(p.waiters) == 0 {
+			p.stopLocked()
+		}
+	}
+	return w.ch, cancel
+}
+
+// stop stops polling and notifies the waiters left.
+func (p *poller) stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for id, ws := range p.waiters {
+		for _, w := range ws {
+			w.ch <- pollResult{err: errors.New("leonardo: client stopped")}
+		}
+		p.remove(id)
+	}
+	p.stopLocked()
+}
+
+// stopLocked stops the poll loop. It must be called with the lock held.
+func (p *poller) stopLocked() {
+	if !p.running {
+		return
+	}
+	p.cancel()
+	p.running = false
+	p.failures = 0
+}
+
+// remove forgets a generation. It must be called with the lock held.
+func (p *poller) remove(id string) {
+	delete(p.waiters, id)
+	delete(p.statuses, id)
+	delete(p.misses, id)
+}
+
+func (p *poller) run(ctx context.Context) {
+	var last time.Time
+	for {
+		p.lock.Lock()
+		if ctx.Err() != nil {
+			// The loop was stopped, a new one may be running
+			p.lock.Unlock()
+			return
+		}
+		if len(p.waiters) == 0 {
+			p.stopLocked()
+			p.lock.Unlock()
+			return
+		}
+		wait := time.Until(last.Add(p.interval(time.Now())))
+		p.lock.Unlock()
+
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			case <-p.wake:
+				// Recalculate the wait with the new generations
+				t.Stop()
+				continue
+			}
+		}
+		last = time.Now()
+		p.poll(ctx)
+	}
+}
+
+// interval returns the wait between polls for the current waiters. It must
+// be called with the lock held.
+func (p *poller) interval(now time.Time) time.Duration {
+	var youngest time.Duration = -1
+	for _, ws := range p.waiters {
+		for _, w := range ws {
+			if age := now.Sub(w.since); youngest < 0 || age < youngest {
+				youngest = age
+			}
+		}
+	}
+	return adaptiveInterval(p.c.pollInterval, len(p.waiters), youngest)
+}
+
+// adaptiveInterval returns the wait between polls. Recent generations are
+// polled at the base interval, and polling slows down as they get older.
+// The more generations are pending, the less it slows down, because any of
+// them may finish soon.
+func adaptiveInterval(base time.Duration, pending int, youngest time.Duration) time.Duration {
+	d := base
+	if youngest > pollBackoffAfter {
+		d = time.Duration(float64(base) * float64(youngest) / float64(pollBackoffAfter))
+	}
+	max := maxPollFactor * base
+	if pending > 1 {
+		max /= time.Duration(pending)
+	}
+	if max < base {
+		max = base
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// poll requests the status of all the generations and notifies the waiters
+// of the ones that are done. Generations missing from the response and
+// failed requests are polled again, up to notFoundAttempts and
+// pollErrorAttempts times.
+func (p *poller) poll(ctx context.Context) {
+	p.lock.Lock()
+	ids := make([]string, 0, len(p.waiters))
+	for id := range p.waiters {
+		ids = append(ids, id)
+	}
+	p.lock.Unlock()
+	sort.Strings(ids)
+
+	// The request isn't bound to any waiter, so it isn't canceled if one of
+	// them leaves, only when all of them do
+	gens, err := p.c.backend.getStatuses(ctx, ids)
+	if ctx.Err() != nil {
+		return
+	}
+	statuses := map[string]string{}
+	var changed []Generation
+	p.lock.Lock()
+	for _, g := range gens {
+		statuses[g.ID] = g.Status
+		if _, ok := p.waiters[g.ID]; ok && p.statuses[g.ID] != g.Status {
+			p.statuses[g.ID] = g.Status
+			changed = append(changed, g)
+		}
+	}
+	p.lock.Unlock()
+
+	// Events are emitted without the lock, the observer may be slow
+	for _, g := range changed {
+		p.c.emit(Event{Type: EventStatusChanged, GenerationID: g.ID, Status: g.Status})
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err != nil {
+		p.failures++
+		if p.failures < pollErrorAttempts {
+			p.c.log("leonardo: couldn't poll statuses, retrying: %v", err)
+			return
+		}
+	}
+	p.failures = 0
+	for _, id := range ids {
+		status, found := statuses[id]
+		if err == nil && !found {
+			p.misses[id]++
+			if p.misses[id] < notFoundAttempts {
+				continue
+			}
+		} else {
+			delete(p.misses, id)
+		}
+		if err == nil && found && status != StatusComplete && status != StatusFailed {
+			continue
+		}
+		for _, w := range p.waiters[id] {
+			w.ch <- pollResult{status: status, err: err}
+		}
+		p.remove(id)
+	}
+}
@@ -0,0 +1,229 @@
+package leonardo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+	base := 5 * time.Second
+	tests := []struct {
+		pending  int
+		youngest time.Duration
+		want     time.Duration
+	}{
+		{1, 0, base},
+		{1, time.Minute, base},
+		{1, 2 * time.Minute, 2 * base},
+		{1, time.Hour, 6 * base},
+		{2, time.Hour, 3 * base},
+		{20, time.Hour, base},
+	}
+	for _, tt := range tests {
+		if got := adaptiveInterval(base, tt.pending, tt.youngest); got != tt.want {
+			t.Errorf("adaptiveInterval(%d, %s) = %s, want %s", tt.pending, tt.youngest, got, tt.want)
+		}
+	}
+}
+
+func TestPollerBatches(t *testing.T) {
+	ids := []string{"gen-1", "gen-2", "gen-3", "gen-4", "gen-5"}
+
+	// Generations only fail once they are polled together, so waiters only
+	// finish if their polls are batched
+	var lock sync.Mutex
+	var requests int
+	var failed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			OperationName string `json:"operationName"`
+			Variables     struct {
+				Where struct {
+					ID struct {
+						In []string `json:"_in"`
+					} `json:"id"`
+				} `json:"where"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		// Failed generations aren't in the feed
+		if req.OperationName == "GetAIGenerationFeed" {
+			fmt.Fprint(w, `{"data":{"generations":[]}}`)
+			return
+		}
+		in := req.Variables.Where.ID.In
+		lock.Lock()
+		requests++
+		if len(in) == len(ids) {
+			failed = true
+		}
+		status := StatusPending
+		if failed {
+			status = StatusFailed
+		}
+		lock.Unlock()
+		var gens []string
+		for _, id := range in {
+			gens = append(gens, fmt.Sprintf(`{"id":%q,"status":%q}`, id, status))
+		}
+		fmt.Fprintf(w, `{"data":{"generations":[%s]}}`, strings.Join(gens, ","))
+	}))
+	defer srv.Close()
+
+	c := New(&Config{
+		Wait:          time.Millisecond,
+		Authenticator: NewTokenAuthenticator(standInToken, "user-1"),
+		Backend:       BackendGraphQL,
+		APIURL:        srv.URL + "/v1/",
+	})
+	c.pollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		i, id := i, id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gens, err := c.WaitGenerations(ctx, []string{id})
+			if err == nil && gens[0].Status != StatusFailed {
+				err = fmt.Errorf("status = %s, want %s", gens[0].Status, StatusFailed)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("%s: %v", ids[i], err)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if requests > 2*len(ids) {
+		t.Errorf("requests = %d, want polls to be shared", requests)
+	}
+}
+
+func TestPollerRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// missing and failing are the number of status responses without
+		// the generation and with an error, negative for all of them
+		missing int
+		failing int
+		wantErr error
+	}{
+		{"missing once", 1, 0, nil},
+		{"missing", -1, 0, ErrNotFound},
+		{"failing once", 0, 1, nil},
+		{"failing", 0, -1, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var polls int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req graphqlRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, "bad request", http.StatusBadRequest)
+					return
+				}
+				if req.OperationName == "GetAIGenerationFeed" {
+					fmt.Fprint(w, `{"data":{"generations":[{"id":"gen-1","status":"COMPLETE"}]}}`)
+					return
+				}
+				lock.Lock()
+				polls++
+				n := polls
+				lock.Unlock()
+				switch {
+				case tt.failing < 0 || n <= tt.failing:
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+				case tt.missing < 0 || n <= tt.missing:
+					fmt.Fprint(w, `{"data":{"generations":[]}}`)
+				default:
+					fmt.Fprint(w, `{"data":{"generations":[{"id":"gen-1","status":"COMPLETE"}]}}`)
+				}
+			}))
+			defer srv.Close()
+
+			c := New(&Config{
+				Wait:          time.Millisecond,
+				Authenticator: NewTokenAuthenticator(standInToken, "user-1"),
+				Backend:       BackendGraphQL,
+				APIURL:        srv.URL + "/v1/",
+			})
+			c.pollInterval = time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := c.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			gens, err := c.WaitGenerations(ctx, []string{"gen-1"})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gens[0].Status != StatusComplete {
+				t.Errorf("status = %s, want %s", gens[0].Status, StatusComplete)
+			}
+		})
+	}
+}
+
+func TestPollerCancel(t *testing.T) {
+	canceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The status request hangs until it is canceled
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer srv.Close()
+
+	c := New(&Config{
+		Wait:          time.Millisecond,
+		Authenticator: NewTokenAuthenticator(standInToken, "user-1"),
+		Backend:       BackendGraphQL,
+		APIURL:        srv.URL + "/v1/",
+	})
+	c.pollInterval = time.Millisecond
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitGenerations(ctx, []string{"gen-1"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// The request is canceled once nobody is waiting
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("status request wasn't canceled")
+	}
+}
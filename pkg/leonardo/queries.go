@@ -26,6 +26,21 @@ var statusQuery = `query GetAIGenerationFeedStatuses($where: generations_bool_ex
   }
 }`
 
+var initImageQuery = `query GetInitImage($id: uuid!) {
+  init_images_by_pk(id: $id) {
+    id
+    __typename
+  }
+}`
+
+var statusSubscription = `subscription GetAIGenerationFeedStatuses($where: generations_bool_exp = {}) {
+  generations(where: $where) {
+    id
+    status
+    __typename
+  }
+}`
+
 var feedQuery = `query GetAIGenerationFeed($where: generations_bool_exp = {}, $userId: uuid, $limit: Int, $offset: Int = 0) {
   generations(
     limit: $limit
@@ -64,6 +79,7 @@ var feedQuery = `query GetAIGenerationFeed($where: generations_bool_exp = {}, $u
     imagePromptStrength
     expandedDomain
     motion
+    motionStrength
     photoReal
     photoRealStrength
     nsfw
@@ -0,0 +1,174 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// AuthEventType is the type of an AuthEvent.
+type AuthEventType string
+
+const (
+	// AuthRefreshed is emitted when the access token has been renewed.
+	AuthRefreshed AuthEventType = "refreshed"
+	// AuthRefreshFailed is emitted when the access token couldn't be renewed.
+	AuthRefreshFailed AuthEventType = "refresh-failed"
+	// AuthReloginNeeded is emitted when the session cookie is about to expire
+	// or has already expired, and a new cookie must be provided.
+	AuthReloginNeeded AuthEventType = "relogin-needed"
+)
+
+// AuthEvent is emitted by the token refresher.
+type AuthEvent struct {
+	Type AuthEventType
+	// TokenExpiration is when the access token will be renewed, in local time.
+	TokenExpiration time.Time
+	// SessionExpires is when the session cookie expires, in local time.
+	SessionExpires time.Time
+	// ClockSkew is the server time minus the local time.
+	ClockSkew time.Duration
+	Err       error
+}
+
+const (
+	// refreshMargin is how long before the token expiration it is renewed.
+	refreshMargin = 1 * time.Minute
+	// refreshRetry is how long to wait before retrying a failed renewal, it
+	// doubles with every consecutive failure up to refreshMaxRetry.
+	refreshRetry    = 30 * time.Second
+	refreshMaxRetry = 30 * time.Minute
+)
+
+// startRefresher launches the background refresher if it is enabled.
+func (a *cookieAuth) startRefresher() {
+	if !a.autoRefresh || a.refreshCancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a.refreshCancel = cancel
+	a.refreshWG.Add(1)
+	go func() {
+		defer a.refreshWG.Done()
+		a.refresher(ctx)
+	}()
+}
+
+// stopRefresher stops the background refresher and waits for it to finish.
+func (a *cookieAuth) stopRefresher() {
+	if a.refreshCancel == nil {
+		return
+	}
+	a.refreshCancel()
+	a.refreshWG.Wait()
+	a.refreshCancel = nil
+}
+
+// refresher renews the access token before it expires until the context is
+// cancelled.
+func (a *cookieAuth) refresher(ctx context.Context) {
+	var warned time.Time
+	a.checkSession(&warned)
+	var wait time.Duration
+	var failures int
+	var relogin bool
+	for {
+		if wait == 0 {
+			_, expiration := a.tokenState()
+			wait = time.Until(expiration) - refreshMargin
+		}
+		if wait < 5*time.Second {
+			wait = 5 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = 0
+
+		a.authLock.Lock()
+		err := a.refreshToken(ctx)
+		a.authLock.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			wait = a.refreshFailed(err, failures, &relogin)
+			continue
+		}
+		failures = 0
+		relogin = false
+		a.emitAuth(a.authEvent(AuthRefreshed, nil))
+		a.checkSession(&warned)
+	}
+}
+
+// refreshFailed emits the events of a failed renewal and returns how long to
+// wait before retrying it. failures is the number of consecutive failures.
+// A relogin is only requested once until a renewal succeeds, relogin tracks
+// whether it was already requested.
+func (a *cookieAuth) refreshFailed(err error, failures int, relogin *bool) time.Duration {
+	a.emitAuth(a.authEvent(AuthRefreshFailed, err))
+	if errors.Is(err, ErrNoSession) && !*relogin {
+		*relogin = true
+		a.emitAuth(a.authEvent(AuthReloginNeeded, err))
+	}
+	wait := refreshRetry
+	for i := 1; i < failures && wait < refreshMaxRetry; i++ {
+		wait *= 2
+	}
+	if wait > refreshMaxRetry {
+		wait = refreshMaxRetry
+	}
+	return wait
+}
+
+// checkSession emits a relogin event once per session if the session cookie
+// expires within the relogin warning.
+func (a *cookieAuth) checkSession(warned *time.Time) {
+	a.tokenLock.RLock()
+	expires := a.sessionExpires
+	a.tokenLock.RUnlock()
+	if expires.IsZero() || expires.Equal(*warned) {
+		return
+	}
+	if time.Until(expires) > a.reloginWarning {
+		return
+	}
+	*warned = expires
+	a.emitAuth(a.authEvent(AuthReloginNeeded, nil))
+}
+
+func (a *cookieAuth) authEvent(typ AuthEventType, err error) AuthEvent {
+	a.tokenLock.RLock()
+	defer a.tokenLock.RUnlock()
+	return AuthEvent{
+		Type:            typ,
+		TokenExpiration: a.tokenExpiration,
+		SessionExpires:  a.sessionExpires,
+		ClockSkew:       a.clockSkew,
+		Err:             err,
+	}
+}
+
+func (a *cookieAuth) emitAuth(e AuthEvent) {
+	if a.onAuthEvent != nil {
+		a.onAuthEvent(e)
+		return
+	}
+	switch e.Type {
+	case AuthRefreshed:
+		a.c.log("leonardo: access token refreshed, expires at %s", e.TokenExpiration.Format(time.RFC3339))
+	case AuthRefreshFailed:
+		log.Printf("leonardo: couldn't refresh access token: %v\n", e.Err)
+	case AuthReloginNeeded:
+		if e.SessionExpires.IsZero() {
+			log.Println("leonardo: session expired, a new login is needed")
+		} else {
+			log.Printf("leonardo: session expires at %s, a new login is needed\n", e.SessionExpires.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package leonardo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRefreshTokenClockSkew(t *testing.T) {
+	// The server clock is one hour ahead of the local clock
+	skew := time.Hour
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		serverNow := time.Now().Add(skew)
+		body := fmt.Sprintf(`{"accessToken":"token","accessTokenExpiry":%d,"serverTimestamp":%d,"expires":%q}`,
+			serverNow.Add(10*time.Minute).Unix(), serverNow.UnixMilli(), serverNow.Add(12*time.Hour).UTC().Format(time.RFC3339))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Header:     http.Header{},
+			Request:    req,
+		}, nil
+	})
+	jar, _ := cookiejar.New(nil)
+	var events []AuthEvent
+	c := New(&Config{
+		Wait:        time.Millisecond,
+		Client:      &http.Client{Transport: transport, Jar: jar},
+		OnAuthEvent: func(e AuthEvent) { events = append(events, e) },
+	})
+	if err := c.Auth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a := c.auth.(*cookieAuth)
+	_, expiration := a.tokenState()
+	want := time.Now().Add(9 * time.Minute)
+	if d := expiration.Sub(want); d > 5*time.Second || d < -5*time.Second {
+		t.Errorf("got expiration in %s, want around 9m", time.Until(expiration).Round(time.Second))
+	}
+
+	// The session expires within the relogin warning
+	var warned time.Time
+	a.checkSession(&warned)
+	a.checkSession(&warned)
+	if len(events) != 1 || events[0].Type != AuthReloginNeeded {
+		t.Fatalf("expected one relogin event, got %+v", events)
+	}
+	if d := time.Until(events[0].SessionExpires) - 12*time.Hour; d > 5*time.Second || d < -5*time.Second {
+		t.Errorf("got session expiration in %s, want around 12h", time.Until(events[0].SessionExpires).Round(time.Second))
+	}
+}
+
+func TestCachedTokenSession(t *testing.T) {
+	ctx := context.Background()
+	store := NewCookieStore(filepath.Join(t.TempDir(), "cookie.txt"))
+	if err := store.SetCookie(ctx, "session=abc"); err != nil {
+		t.Fatal(err)
+	}
+	var events []AuthEvent
+	c := New(&Config{
+		CookieStore: store,
+		AppURL:      "http://leonardo.test/",
+		OnAuthEvent: func(e AuthEvent) { events = append(events, e) },
+	})
+	a := c.auth.(*cookieAuth)
+	if err := a.loadCookie(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sessionExpires := time.Now().Add(time.Hour).Round(time.Second)
+	if err := store.(TokenStore).SetToken(ctx, &Token{
+		AccessToken:    "token",
+		Expiration:     time.Now().Add(time.Hour),
+		UserID:         "user",
+		CookieHash:     a.cookieHash(),
+		SessionExpires: sessionExpires,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.loadToken(ctx) {
+		t.Fatal("expected the cached token to be loaded")
+	}
+
+	// The session expiration of the cached token is checked
+	var warned time.Time
+	a.checkSession(&warned)
+	if len(events) != 1 || events[0].Type != AuthReloginNeeded || !events[0].SessionExpires.Equal(sessionExpires) {
+		t.Fatalf("expected one relogin event, got %+v", events)
+	}
+}
+
+func TestRefreshFailed(t *testing.T) {
+	var events []AuthEvent
+	c := New(&Config{
+		CookieStore: NewCookieStore(filepath.Join(t.TempDir(), "cookie.txt")),
+		OnAuthEvent: func(e AuthEvent) { events = append(events, e) },
+	})
+	a := c.auth.(*cookieAuth)
+
+	// Retries back off and the relogin is only requested once
+	var relogin bool
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute}
+	for i, w := range want {
+		if got := a.refreshFailed(ErrNoSession, i+1, &relogin); got != w {
+			t.Errorf("failure %d: got wait %s, want %s", i+1, got, w)
+		}
+	}
+	if got := a.refreshFailed(ErrNoSession, 100, &relogin); got != refreshMaxRetry {
+		t.Errorf("got wait %s, want %s", got, refreshMaxRetry)
+	}
+	var failed, relogins int
+	for _, e := range events {
+		switch e.Type {
+		case AuthRefreshFailed:
+			failed++
+		case AuthReloginNeeded:
+			relogins++
+		}
+	}
+	if failed != 4 || relogins != 1 {
+		t.Errorf("got %d failed and %d relogin events, want 4 and 1", failed, relogins)
+	}
+}
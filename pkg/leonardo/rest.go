@@ -0,0 +1,178 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// restBackend uses the official REST API.
+type restBackend struct {
+	c *Client
+}
+
+type restUploadResponse struct {
+	UploadInitImage struct {
+		ID     string `json:"id"`
+		Fields string `json:"fields"`
+		Key    string `json:"key"`
+		URL    string `json:"url"`
+	} `json:"uploadInitImage"`
+}
+
+func (b *restBackend) createUpload(ctx context.Context, fileType, ext string) (*uploadTarget, error) {
+	req := map[string]any{
+		"extension": ext,
+	}
+	var resp restUploadResponse
+	if _, err := b.c.do(ctx, "CreateUploadInitImage", "POST", b.c.restURL+"init-image", req, &resp); err != nil {
+		return nil, err
+	}
+	upload := resp.UploadInitImage
+	return b.c.newUploadTarget(upload.ID, upload.URL, upload.Fields)
+}
+
+type restMotionResponse struct {
+	MotionSVDGenerationJob struct {
+		GenerationID  string `json:"generationId"`
+		APICreditCost int    `json:"apiCreditCost"`
+	} `json:"motionSvdGenerationJob"`
+}
+
+func (b *restBackend) createMotion(ctx context.Context, imageID string, motionStrength int) (string, int, error) {
+	req := map[string]any{
+		"imageId":        imageID,
+		"isInitImage":    true,
+		"isPublic":       false,
+		"motionStrength": motionStrength,
+	}
+	var resp restMotionResponse
+	var reused string
+	guard, unlock := b.c.motionGuard(ctx, imageID, motionStrength, &reused)
+	defer unlock()
+	if _, err := b.c.doGuarded(ctx, "CreateMotionSvdGenerationJob", "POST", b.c.restURL+"generations-motion-svd", req, &resp, guard); err != nil {
+		return "", 0, err
+	}
+	if reused != "" {
+		return reused, 0, nil
+	}
+	return resp.MotionSVDGenerationJob.GenerationID, resp.MotionSVDGenerationJob.APICreditCost, nil
+}
+
+type restUserGenerationsResponse struct {
+	Generations []struct {
+		ID             string `json:"id"`
+		CreatedAt      string `json:"createdAt"`
+		Motion         bool   `json:"motion"`
+		MotionStrength int    `json:"motionStrength"`
+		InitImageID    string `json:"initImageId"`
+	} `json:"generations"`
+}
+
+func (b *restBackend) recentMotions(ctx context.Context) ([]submittedMotion, error) {
+	var resp restUserGenerationsResponse
+	u := fmt.Sprintf("%sgenerations/user/%s?offset=0&limit=10", b.c.restURL, url.PathEscape(b.c.userID))
+	if _, err := b.c.do(ctx, "GetGenerationsByUserId", "GET", u, nil, &resp); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't get user generations: %w", err)
+	}
+	var gens []submittedMotion
+	for _, g := range resp.Generations {
+		if !g.Motion {
+			continue
+		}
+		createdAt, err := parseCreatedAt(g.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		gens = append(gens, submittedMotion{
+			ID:             g.ID,
+			InitImageID:    g.InitImageID,
+			MotionStrength: g.MotionStrength,
+			CreatedAt:      createdAt,
+		})
+	}
+	return gens, nil
+}
+
+type restGenerationResponse struct {
+	GenerationsByPK *struct {
+		ID              string `json:"id"`
+		Status          string `json:"status"`
+		CreatedAt       string `json:"createdAt"`
+		Nsfw            bool   `json:"nsfw"`
+		Reason          string `json:"reason"`
+		GeneratedImages []struct {
+			ID           string  `json:"id"`
+			URL          string  `json:"url"`
+			MotionMP4URL *string `json:"motionMP4URL"`
+			Nsfw         bool    `json:"nsfw"`
+		} `json:"generated_images"`
+	} `json:"generations_by_pk"`
+}
+
+func (b *restBackend) getGeneration(ctx context.Context, id, status string) (*Generation, error) {
+	var resp restGenerationResponse
+	if _, err := b.c.do(ctx, "GetGeneration", "GET", b.c.restURL+"generations/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't get generation: %w", err)
+	}
+	g := resp.GenerationsByPK
+	if g == nil {
+		return nil, fmt.Errorf("leonardo: couldn't find generation %s: %w", id, ErrNotFound)
+	}
+	gen := &Generation{
+		ID:        g.ID,
+		Status:    g.Status,
+		CreatedAt: g.CreatedAt,
+		NSFW:      g.Nsfw,
+		Reason:    g.Reason,
+	}
+	for _, img := range g.GeneratedImages {
+		var mp4 string
+		if img.MotionMP4URL != nil {
+			mp4 = *img.MotionMP4URL
+		}
+		gen.Images = append(gen.Images, GeneratedImage{
+			ID:           img.ID,
+			URL:          img.URL,
+			MotionMP4URL: mp4,
+			NSFW:         img.Nsfw,
+		})
+	}
+	return gen, nil
+}
+
+// getStatuses gets the generations one by one, the REST API doesn't have a
+// status query.
+func (b *restBackend) getStatuses(ctx context.Context, ids []string) ([]Generation, error) {
+	var gens []Generation
+	for _, id := range ids {
+		g, err := b.getGeneration(ctx, id, "")
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		gens = append(gens, Generation{ID: g.ID, Status: g.Status})
+	}
+	return gens, nil
+}
+
+type restInitImageResponse struct {
+	InitImagesByPK *struct {
+		ID string `json:"id"`
+	} `json:"init_images_by_pk"`
+}
+
+func (b *restBackend) initImageExists(ctx context.Context, id string) (bool, error) {
+	var resp restInitImageResponse
+	_, err := b.c.do(ctx, "GetInitImageById", "GET", b.c.restURL+"init-image/"+url.PathEscape(id), nil, &resp)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("leonardo: couldn't get init image: %w", err)
+	}
+	return resp.InitImagesByPK != nil, nil
+}
@@ -0,0 +1,136 @@
+package leonardo
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, it doubles on every
+	// retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of the delay that is randomized, from 0 to 1.
+	// Zero uses the default, a negative value disables it.
+	Jitter float64
+	// RetryStatus are the HTTP status codes that are retried.
+	RetryStatus []int
+	// RetryCodes are the API error codes that are retried. If empty, any API
+	// error is retried.
+	RetryCodes []string
+	// Operations overrides the policy for the operations with the given
+	// names, like CreateMotionSvdGenerationJob. Zero fields are taken from
+	// the default policy.
+	Operations map[string]RetryPolicy
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    2 * time.Minute,
+		Jitter:      0.2,
+		RetryStatus: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// withDefaults returns a copy of the policy with its zero fields taken from
+// def.
+func (p RetryPolicy) withDefaults(def *RetryPolicy) *RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = def.MaxAttempts
+	}
+	if p.BaseDelay == 0 {
+		p.BaseDelay = def.BaseDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = def.MaxDelay
+	}
+	if p.Jitter == 0 {
+		p.Jitter = def.Jitter
+	}
+	if p.RetryStatus == nil {
+		p.RetryStatus = def.RetryStatus
+	}
+	if p.RetryCodes == nil {
+		p.RetryCodes = def.RetryCodes
+	}
+	if p.Operations == nil {
+		p.Operations = def.Operations
+	}
+	return &p
+}
+
+// operation returns the policy of an operation.
+func (p *RetryPolicy) operation(op string) *RetryPolicy {
+	if o, ok := p.Operations[op]; ok {
+		return o.withDefaults(p)
+	}
+	return p
+}
+
+func (p *RetryPolicy) retryStatus(status int) bool {
+	for _, s := range p.RetryStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryCode(code string) bool {
+	if len(p.RetryCodes) == 0 {
+		return true
+	}
+	for _, c := range p.RetryCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait before the given retry, starting at 1.
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		// Randomize the delay within +/- jitter
+		j := float64(d) * p.Jitter
+		d = time.Duration(float64(d) - j + rand.Float64()*2*j)
+	}
+	return d
+}
+
+// parseRetryAfter parses a Retry-After header with either seconds or a date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+package leonardo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: -1}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("delay with jitter = %s, out of range", d)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{"invalid", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := New(&Config{
+		Wait:          time.Millisecond,
+		Authenticator: NewTokenAuthenticator(standInToken, "user-1"),
+		APIURL:        srv.URL,
+		Retry: &RetryPolicy{
+			MaxAttempts: 2,
+			BaseDelay:   time.Millisecond,
+			Operations: map[string]RetryPolicy{
+				"NoRetry": {MaxAttempts: 1},
+				"Capped":  {MaxDelay: 10 * time.Millisecond},
+			},
+		},
+	})
+	ctx := context.Background()
+
+	// Retry-After is honoured instead of the base delay
+	start := time.Now()
+	if _, err := c.do(ctx, "Op", "GET", "retry", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least 1s", elapsed)
+	}
+
+	// Retry-After is capped to the maximum delay
+	atomic.StoreInt32(&calls, 0)
+	start = time.Now()
+	if _, err := c.do(ctx, "Capped", "GET", "retry", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("retried after %s, want less than 1s", elapsed)
+	}
+
+	// The operation override disables retries
+	atomic.StoreInt32(&calls, 0)
+	_, err := c.do(ctx, "NoRetry", "GET", "retry", nil, nil)
+	var errHTTP *HTTPError
+	if !errors.As(err, &errHTTP) || errHTTP.RetryAfter != time.Second {
+		t.Errorf("expected http error with retry after, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+func TestRetryStaleCachedToken(t *testing.T) {
+	var graphqlRequests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth/session":
+			fmt.Fprintf(w, `{"accessToken":"new","accessTokenExpiry":%d}`, time.Now().Add(time.Hour).Unix())
+		case "/v1/graphql":
+			graphqlRequests.Add(1)
+			if r.Header.Get("Authorization") != "Bearer new" {
+				fmt.Fprint(w, `{"errors":[{"message":"Could not verify JWT: JWTExpired","extensions":{"code":"invalid-jwt"}}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"data":{}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := NewCookieStore(filepath.Join(t.TempDir(), "cookie.txt"))
+	if err := store.SetCookie(ctx, "session=abc"); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&Config{
+		Wait:        time.Millisecond,
+		CookieStore: store,
+		AppURL:      srv.URL,
+		APIURL:      srv.URL + "/v1/",
+		// The refresh after an invalid token isn't counted as an attempt
+		Retry: &RetryPolicy{MaxAttempts: 1},
+	})
+	a := c.auth.(*cookieAuth)
+	if err := a.loadCookie(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The cached token was revoked by the server
+	ts := store.(TokenStore)
+	if err := ts.SetToken(ctx, &Token{
+		AccessToken: "stale",
+		Expiration:  time.Now().Add(time.Hour),
+		UserID:      "user",
+		CookieHash:  a.cookieHash(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Stop(ctx) }()
+
+	var resp struct{}
+	if _, err := c.do(ctx, "Op", "POST", "graphql", map[string]any{}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if n := graphqlRequests.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+	got, err := ts.GetToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.AccessToken != "new" {
+		t.Errorf("expected the stale cached token to be replaced, got %+v", got)
+	}
+}
@@ -0,0 +1,268 @@
+package leonardo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/igolaizola/leonai/pkg/crypt"
+)
+
+type CookieStore interface {
+	GetCookie(context.Context) (string, error)
+	SetCookie(context.Context, string) error
+}
+
+// TokenStore is implemented by cookie stores that can also cache the access
+// token between runs.
+type TokenStore interface {
+	// GetToken returns the cached token or nil if there is none.
+	GetToken(context.Context) (*Token, error)
+	// SetToken caches the token, a nil token removes it.
+	SetToken(context.Context, *Token) error
+}
+
+// Token is an access token cached by a TokenStore.
+type Token struct {
+	AccessToken string    `json:"accessToken"`
+	Expiration  time.Time `json:"expiration"`
+	UserID      string    `json:"userId"`
+	// CookieHash is the hash of the session cookie the token was obtained
+	// with, so the token isn't reused after the cookie is replaced.
+	CookieHash string `json:"cookieHash"`
+	// SessionExpires is when the session cookie expires, in local time.
+	SessionExpires time.Time     `json:"sessionExpires"`
+	ClockSkew      time.Duration `json:"clockSkew"`
+}
+
+type cookieStore struct {
+	path string
+}
+
+// NewCookieStore creates a cookie store backed by a file.
+// The file is written atomically with owner-only permissions and an advisory
+// lock is held while reading or writing it, so several processes can share it.
+func NewCookieStore(path string) CookieStore {
+	return &cookieStore{
+		path: path,
+	}
+}
+
+func (c *cookieStore) GetCookie(ctx context.Context) (string, error) {
+	b, err := readFile(c.path)
+	if err != nil {
+		return "", fmt.Errorf("leonardo: couldn't read cookie: %w", err)
+	}
+	return string(b), nil
+}
+
+func (c *cookieStore) SetCookie(ctx context.Context, cookie string) error {
+	if err := writeFile(c.path, []byte(cookie)); err != nil {
+		return fmt.Errorf("leonardo: couldn't write cookie: %w", err)
+	}
+	return nil
+}
+
+func (c *cookieStore) GetToken(ctx context.Context) (*Token, error) {
+	b, err := readFile(tokenPath(c.path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't read token: %w", err)
+	}
+	return unmarshalToken(b)
+}
+
+func (c *cookieStore) SetToken(ctx context.Context, t *Token) error {
+	if t == nil {
+		return removeToken(c.path)
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't marshal token: %w", err)
+	}
+	if err := writeFile(tokenPath(c.path), b); err != nil {
+		return fmt.Errorf("leonardo: couldn't write token: %w", err)
+	}
+	return nil
+}
+
+type encryptedCookieStore struct {
+	path   string
+	secret []byte
+}
+
+// NewEncryptedCookieStore creates a cookie store backed by a file encrypted
+// with a key derived from secret.
+func NewEncryptedCookieStore(path string, secret []byte) CookieStore {
+	return &encryptedCookieStore{
+		path:   path,
+		secret: secret,
+	}
+}
+
+func (c *encryptedCookieStore) GetCookie(ctx context.Context) (string, error) {
+	b, err := readFile(c.path)
+	if err != nil {
+		return "", fmt.Errorf("leonardo: couldn't read cookie: %w", err)
+	}
+	b, err = crypt.Decrypt(c.secret, b)
+	if errors.Is(err, crypt.ErrNotEncrypted) {
+		return "", fmt.Errorf("leonardo: cookie file %s is not encrypted", c.path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("leonardo: couldn't decrypt cookie: %w", err)
+	}
+	return string(b), nil
+}
+
+func (c *encryptedCookieStore) SetCookie(ctx context.Context, cookie string) error {
+	b, err := crypt.Encrypt(c.secret, []byte(cookie))
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't encrypt cookie: %w", err)
+	}
+	if err := writeFile(c.path, b); err != nil {
+		return fmt.Errorf("leonardo: couldn't write cookie: %w", err)
+	}
+	return nil
+}
+
+func (c *encryptedCookieStore) GetToken(ctx context.Context) (*Token, error) {
+	b, err := readFile(tokenPath(c.path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't read token: %w", err)
+	}
+	b, err = crypt.Decrypt(c.secret, b)
+	if err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't decrypt token: %w", err)
+	}
+	return unmarshalToken(b)
+}
+
+func (c *encryptedCookieStore) SetToken(ctx context.Context, t *Token) error {
+	if t == nil {
+		return removeToken(c.path)
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't marshal token: %w", err)
+	}
+	b, err = crypt.Encrypt(c.secret, b)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't encrypt token: %w", err)
+	}
+	if err := writeFile(tokenPath(c.path), b); err != nil {
+		return fmt.Errorf("leonardo: couldn't write token: %w", err)
+	}
+	return nil
+}
+
+// tokenPath returns the path of the token file next to the cookie file.
+func tokenPath(cookiePath string) string {
+	return cookiePath + ".token"
+}
+
+func unmarshalToken(b []byte) (*Token, error) {
+	var t Token
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't unmarshal token: %w", err)
+	}
+	return &t, nil
+}
+
+func removeToken(cookiePath string) error {
+	path := tokenPath(cookiePath)
+	unlock, err := lockFile(path, true)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't remove token: %w", err)
+	}
+	defer unlock()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("leonardo: couldn't remove token: %w", err)
+	}
+	return nil
+}
+
+// readFile reads a file holding a shared lock on it.
+func readFile(path string) ([]byte, error) {
+	unlock, err := lockFile(path, false)
+	switch {
+	case err == nil:
+		defer unlock()
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission), readOnly(err):
+		// Writes are atomic, so the file can still be read without the lock
+		// if it was never written or its directory is read-only.
+	default:
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// writeFile writes data to a temporary file and renames it to path while
+// holding an exclusive lock, so readers never see a partial write.
+// The file is only readable by its owner.
+func writeFile(path string, data []byte) error {
+	unlock, err := lockFile(path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, fmt.Sprintf(".%s.tmp-*", base))
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer func() { _ = os.Remove(tmp) }()
+	if err := f.Chmod(0600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lockFile acquires an advisory lock for path and returns a function that
+// releases it. A separate lock file is used because the locked file is
+// replaced on every write. Only exclusive locks create the lock file.
+func lockFile(path string, exclusive bool) (func(), error) {
+	flag := os.O_RDONLY
+	if exclusive {
+		flag = os.O_CREATE | os.O_RDWR
+	}
+	f, err := os.OpenFile(path+".lock", flag, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open lock file: %w", err)
+	}
+	if err := lock(f, exclusive); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("couldn't lock file: %w", err)
+	}
+	return func() {
+		_ = unlock(f)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,258 @@
+package leonardo
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCookieStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cookie.txt")
+	if err := os.WriteFile(path, []byte("old=1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewCookieStore(path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.SetCookie(ctx, fmt.Sprintf("a=%d; b=%s", i, strings.Repeat("x", 4096))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := store.GetCookie(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "a=") || !strings.HasSuffix(got, strings.Repeat("x", 4096)) {
+		t.Errorf("unexpected cookie: %.20s...", got)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("got permissions %o, want 600", perm)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("temporary file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestCookieStoreReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookie.txt")
+	if err := os.WriteFile(path, []byte("session=abc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		if err := os.Chmod(dir, 0500); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = os.Chmod(dir, 0700) }()
+	}
+
+	// Reading doesn't need to create the lock file
+	got, err := NewCookieStore(path).GetCookie(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "session=abc" {
+		t.Errorf("got cookie %q, want session=abc", got)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("expected no lock file, got %v", err)
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	stores := map[string]CookieStore{
+		"plain":     NewCookieStore(filepath.Join(dir, "plain.txt")),
+		"encrypted": NewEncryptedCookieStore(filepath.Join(dir, "encrypted.txt"), []byte("secret")),
+	}
+	for name, store := range stores {
+		ts := store.(TokenStore)
+		got, err := ts.GetToken(ctx)
+		if err != nil || got != nil {
+			t.Fatalf("%s: expected no token, got %v %v", name, got, err)
+		}
+		want := &Token{
+			AccessToken: "token",
+			Expiration:  time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+			UserID:      "user",
+		}
+		if err := ts.SetToken(ctx, want); err != nil {
+			t.Fatal(err)
+		}
+		got, err = ts.GetToken(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != *want {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+		if err := ts.SetToken(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ts.GetToken(ctx); err != nil || got != nil {
+			t.Errorf("%s: expected removed token, got %v %v", name, got, err)
+		}
+	}
+}
+
+func TestCachedTokenCookie(t *testing.T) {
+	ctx := context.Background()
+	store := NewCookieStore(filepath.Join(t.TempDir(), "cookie.txt"))
+	if err := store.SetCookie(ctx, "session=abc"); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&Config{
+		CookieStore: store,
+		AppURL:      "http://leonardo.test/",
+	})
+	a := c.auth.(*cookieAuth)
+	if err := a.loadCookie(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ts := store.(TokenStore)
+	token := &Token{
+		AccessToken: "token",
+		Expiration:  time.Now().Add(time.Hour),
+		UserID:      "user",
+		CookieHash:  a.cookieHash(),
+	}
+	if err := ts.SetToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if !a.loadToken(ctx) {
+		t.Fatal("expected the cached token to be loaded")
+	}
+
+	// The cookie is replaced by another account's before the next run
+	if err := store.SetCookie(ctx, "session=def"); err != nil {
+		t.Fatal(err)
+	}
+	c = New(&Config{
+		CookieStore: store,
+		AppURL:      "http://leonardo.test/",
+	})
+	a = c.auth.(*cookieAuth)
+	if err := a.loadCookie(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if a.loadToken(ctx) {
+		t.Fatal("expected the cached token to be dropped")
+	}
+	if got, err := ts.GetToken(ctx); err != nil || got != nil {
+		t.Errorf("expected removed token, got %v %v", got, err)
+	}
+}
+
+func TestEncryptedCookieStoreClient(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"sub","https://hasura.io/jwt/claims":"{\"x-hasura-user-id\":\"user\"}"}`))
+	token := "header." + payload + ".signature"
+	var lock sync.Mutex
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/api/auth/session":
+			c, err := r.Cookie("session")
+			if err != nil || c.Value != "abc" {
+				http.Error(w, "missing cookie", http.StatusUnauthorized)
+				return
+			}
+			// The session cookie is rotated
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "def", Path: "/"})
+			fmt.Fprintf(w, `{"accessToken":%q,"accessTokenExpiry":%d}`, token, time.Now().Add(time.Hour).Unix())
+		case "/v1/graphql":
+			fmt.Fprint(w, `{"data":{"users":[{"id":"user"}]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cookie.txt")
+	store := NewEncryptedCookieStore(path, []byte("secret"))
+	if err := store.SetCookie(ctx, "session=abc"); err != nil {
+		t.Fatal(err)
+	}
+	newClient := func(store CookieStore) *Client {
+		return New(&Config{
+			Wait:        time.Millisecond,
+			CookieStore: store,
+			AppURL:      srv.URL,
+			APIURL:      srv.URL + "/v1/",
+		})
+	}
+
+	// A wrong key fails before any request and leaves the file untouched
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newClient(NewEncryptedCookieStore(path, []byte("wrong"))).Start(ctx)
+	if err == nil || !strings.Contains(err.Error(), "couldn't decrypt cookie") {
+		t.Fatalf("expected decrypt error, got %v", err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 0 || string(before) != string(after) {
+		t.Errorf("requests = %d, file changed = %v, want no requests and no changes", requests, string(before) != string(after))
+	}
+
+	// The rotated cookie is written back encrypted
+	c := newClient(store)
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := store.GetCookie(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie != "session=def" {
+		t.Errorf("cookie = %q, want session=def", cookie)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "session") {
+		t.Error("cookie file isn't encrypted")
+	}
+}
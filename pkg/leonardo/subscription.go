@@ -0,0 +1,236 @@
+package leonardo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// graphql-transport-ws protocol, see
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const (
+	wsProtocol = "graphql-transport-ws"
+	// wsAckTimeout is how long the server has to acknowledge the connection.
+	wsAckTimeout = 10 * time.Second
+	// wsPingInterval is how often the client pings the server. The socket is
+	// considered dead if nothing is received in three intervals.
+	wsPingInterval = 15 * time.Second
+	// wsSubscriptionID is the id of the only subscription of each socket.
+	wsSubscriptionID = "1"
+)
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// statusWatcher is implemented by backends that can push status changes.
+type statusWatcher interface {
+	// watchStatuses calls update with the id and status of the generations
+	// found each time any of them changes, until update returns true or an
+	// error.
+	watchStatuses(ctx context.Context, ids []string, update func([]Generation) (bool, error)) error
+}
+
+// subscriptionURL returns the websocket URL of the GraphQL API.
+func (c *Client) subscriptionURL() string {
+	u := c.apiURL + "graphql"
+	switch {
+	case strings.HasPrefix(u, "https://"):
+		return "wss://" + strings.TrimPrefix(u, "https://")
+	case strings.HasPrefix(u, "http://"):
+		return "ws://" + strings.TrimPrefix(u, "http://")
+	}
+	return u
+}
+
+// dialer returns a websocket dialer that uses the proxy and TLS settings of
+// the http client.
+func (c *Client) dialer() *websocket.Dialer {
+	d := &websocket.Dialer{
+		HandshakeTimeout: 30 * time.Second,
+		Subprotocols:     []string{wsProtocol},
+		Proxy:            http.ProxyFromEnvironment,
+	}
+	transport := c.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if t, ok := transport.(*http.Transport); ok {
+		d.Proxy = t.Proxy
+		d.TLSClientConfig = t.TLSClientConfig
+		d.NetDialContext = t.DialContext
+	}
+	return d
+}
+
+func (b *graphqlBackend) watchStatuses(ctx context.Context, ids []string, update func([]Generation) (bool, error)) error {
+	c := b.c
+	creds, err := c.auth.Credentials(ctx)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Origin", strings.TrimSuffix(c.appURL, "/"))
+	header.Set("User-Agent", `Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36`)
+	u := c.subscriptionURL()
+	conn, resp, err := c.dialer().DialContext(ctx, u, header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%w: %w", err, &HTTPError{Status: resp.StatusCode})
+		}
+		return fmt.Errorf("leonardo: couldn't dial %s: %w", u, err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != wsProtocol {
+		return fmt.Errorf("leonardo: unsupported websocket protocol %q", conn.Subprotocol())
+	}
+
+	// Close the socket when the context is done to unblock reads
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	var lock sync.Mutex
+	write := func(m wsMessage) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if err := conn.SetWriteDeadline(time.Now().Add(wsAckTimeout)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(m)
+	}
+	read := func(timeout time.Duration) (*wsMessage, error) {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		var m wsMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("leonardo: couldn't read from websocket: %w", err)
+		}
+		return &m, nil
+	}
+
+	// Hasura reads the credentials from the init payload headers
+	init, err := json.Marshal(map[string]any{
+		"headers": map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", creds.Token),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't marshal connection init: %w", err)
+	}
+	if err := write(wsMessage{Type: "connection_init", Payload: init}); err != nil {
+		return fmt.Errorf("leonardo: couldn't init websocket: %w", err)
+	}
+	m, err := read(wsAckTimeout)
+	if err != nil {
+		return err
+	}
+	if m.Type != "connection_ack" {
+		return fmt.Errorf("leonardo: unexpected websocket message %q, want connection_ack", m.Type)
+	}
+
+	req := &graphqlRequest{
+		OperationName: "GetAIGenerationFeedStatuses",
+		Variables: map[string]any{
+			"where": map[string]any{
+				"id": map[string]any{
+					"_in": ids,
+				},
+			},
+		},
+		Query: statusSubscription,
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't marshal subscription: %w", err)
+	}
+	if err := write(wsMessage{ID: wsSubscriptionID, Type: "subscribe", Payload: payload}); err != nil {
+		return fmt.Errorf("leonardo: couldn't subscribe: %w", err)
+	}
+	c.log("leonardo: subscribed to %d generations", len(ids))
+
+	// Keep the socket alive, the server only sends messages on changes
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := write(wsMessage{Type: "ping"}); err != nil {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		m, err := read(3 * wsPingInterval)
+		if err != nil {
+			return err
+		}
+		switch m.Type {
+		case "ping":
+			if err := write(wsMessage{Type: "pong"}); err != nil {
+				return fmt.Errorf("leonardo: couldn't pong: %w", err)
+			}
+		case "pong":
+		case "next":
+			var resp struct {
+				statusResponse
+				errorResponse
+			}
+			if err := json.Unmarshal(m.Payload, &resp); err != nil {
+				return fmt.Errorf("leonardo: couldn't unmarshal subscription payload: %w", err)
+			}
+			if len(resp.Errors) > 0 {
+				return resp.errorResponse.apiError(req.OperationName)
+			}
+			var gens []Generation
+			for _, g := range resp.Data.Generations {
+				gens = append(gens, Generation{ID: g.ID, Status: g.Status})
+			}
+			done, err := update(gens)
+			if err != nil {
+				return err
+			}
+			if done {
+				_ = write(wsMessage{ID: wsSubscriptionID, Type: "complete"})
+				return nil
+			}
+		case "error":
+			var resp errorResponse
+			if err := json.Unmarshal(m.Payload, &resp.Errors); err != nil {
+				return fmt.Errorf("leonardo: couldn't unmarshal subscription error: %w", err)
+			}
+			if len(resp.Errors) == 0 {
+				return errors.New("leonardo: subscription failed without errors")
+			}
+			return resp.apiError(req.OperationName)
+		case "complete":
+			return errors.New("leonardo: subscription completed by the server")
+		default:
+			return fmt.Errorf("leonardo: unexpected websocket message %q", m.Type)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package leonardo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serveSubscription serves status subscriptions with the graphql-transport-ws
+// protocol, sending PENDING and then COMPLETE.
+func (s *standIn) serveSubscription(w http.ResponseWriter, r *http.Request) {
+	if s.noSocket {
+		http.Error(w, "websockets disabled", http.StatusBadRequest)
+		return
+	}
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{wsProtocol},
+		// The client sends the origin of the web app
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var init struct {
+		Type    string `json:"type"`
+		Payload struct {
+			Headers map[string]string `json:"headers"`
+		} `json:"payload"`
+	}
+	if err := conn.ReadJSON(&init); err != nil || init.Type != "connection_init" {
+		return
+	}
+	if init.Payload.Headers["Authorization"] != "Bearer "+standInToken {
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4403, "Forbidden"))
+		return
+	}
+	_ = conn.WriteJSON(wsMessage{Type: "connection_ack"})
+
+	var sub struct {
+		ID      string         `json:"id"`
+		Type    string         `json:"type"`
+		Payload graphqlRequest `json:"payload"`
+	}
+	if err := conn.ReadJSON(&sub); err != nil || sub.Type != "subscribe" {
+		return
+	}
+	s.lock.Lock()
+	s.subscriptions++
+	s.lock.Unlock()
+	if s.subscriptionError != "" {
+		_ = conn.WriteJSON(wsMessage{ID: sub.ID, Type: "error", Payload: json.RawMessage(s.subscriptionError)})
+		return
+	}
+
+	for _, status := range []string{StatusPending, StatusComplete} {
+		if status == StatusComplete {
+			// The HTTP API sees the generation as complete too
+			s.lock.Lock()
+			s.polls++
+			s.lock.Unlock()
+		}
+		payload := fmt.Sprintf(`{"data":{"generations":[{"id":"gen-1","status":%q}]}}`, status)
+		_ = conn.WriteJSON(wsMessage{ID: sub.ID, Type: "next", Payload: json.RawMessage(payload)})
+	}
+
+	// Wait for the client to complete the subscription
+	var m wsMessage
+	for conn.ReadJSON(&m) == nil && m.Type != "complete" {
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	tests := []struct {
+		name              string
+		noSocket          bool
+		subscriptionError string
+		subscriptions     int
+	}{
+		{"subscription", false, "", 1},
+		{"fallback", true, "", 0},
+		{"error", false, `[{"message":"forbidden"}]`, 1},
+		{"empty error", false, `[]`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &standIn{noSocket: tt.noSocket, subscriptionError: tt.subscriptionError}
+			c := newStandInClient(t, s, BackendGraphQL, &Config{
+				Subscriptions: true,
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			gen, err := c.WaitGeneration(ctx, "gen-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			video, err := gen.Video()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if video.MotionMP4URL != standInMP4 {
+				t.Errorf("video url = %q, want %s", video.MotionMP4URL, standInMP4)
+			}
+			if s.subscriptions != tt.subscriptions {
+				t.Errorf("subscriptions = %d, want %d", s.subscriptions, tt.subscriptions)
+			}
+		})
+	}
+}
+
+func TestSubscriptionURL(t *testing.T) {
+	c := New(&Config{APIURL: "https://api.leonardo.ai/v1"})
+	if got, want := c.subscriptionURL(), "wss://api.leonardo.ai/v1/graphql"; got != want {
+		t.Errorf("subscription url = %q, want %q", got, want)
+	}
+}
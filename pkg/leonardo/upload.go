@@ -0,0 +1,136 @@
+package leonardo
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+)
+
+// DefaultMaxUploadSize is the default maximum size of an uploaded image.
+const DefaultMaxUploadSize = 10 << 20
+
+type formField struct {
+	key   string
+	value string
+}
+
+// form is a multipart form with a file that is streamed from disk every time
+// it is sent.
+type form struct {
+	path        string
+	fileSize    int64
+	boundary    string
+	fields      []formField
+	contentType string
+	size        int64
+	progress    func(sent, total int64)
+}
+
+func newForm(path string, fileSize int64, boundary string, fields []formField, progress func(sent, total int64)) (*form, error) {
+	f := &form{
+		path:     path,
+		fileSize: fileSize,
+		boundary: boundary,
+		fields:   fields,
+		progress: progress,
+	}
+
+	// Write the form without the file contents to get its size
+	var cw countWriter
+	w, err := f.writeHeader(&cw)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't close writer: %w", err)
+	}
+	f.size = cw.n + fileSize
+	f.contentType = w.FormDataContentType()
+	return f, nil
+}
+
+// writeHeader writes the fields and the header of the file part.
+func (f *form) writeHeader(dst io.Writer) (*multipart.Writer, error) {
+	w := multipart.NewWriter(dst)
+	if err := w.SetBoundary(f.boundary); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't set boundary: %w", err)
+	}
+	for _, kv := range f.fields {
+		if err := w.WriteField(kv.key, kv.value); err != nil {
+			return nil, fmt.Errorf("leonardo: couldn't write field %s: %w", kv.key, err)
+		}
+	}
+	if _, err := w.CreateFormFile("file", filepath.Base(f.path)); err != nil {
+		return nil, fmt.Errorf("leonardo: couldn't create form file: %w", err)
+	}
+	return w, nil
+}
+
+// open returns a reader that streams the form through a pipe.
+func (f *form) open() io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(f.write(pw))
+	}()
+	return pr
+}
+
+func (f *form) write(dst io.Writer) error {
+	w, err := f.writeHeader(dst)
+	if err != nil {
+		return err
+	}
+
+	// Open file
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't open file: %w", err)
+	}
+	defer file.Close()
+
+	// Copy file to part
+	var src io.Reader = file
+	if f.progress != nil {
+		src = &progressReader{r: file, total: f.fileSize, fn: f.progress}
+	}
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't copy file to part: %w", err)
+	}
+	if n != f.fileSize {
+		return fmt.Errorf("leonardo: file %s changed size while uploading", f.path)
+	}
+
+	// Close writer
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("leonardo: couldn't close writer: %w", err)
+	}
+	return nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+type progressReader struct {
+	r     io.Reader
+	sent  int64
+	total int64
+	fn    func(sent, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.fn(r.sent, r.total)
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package leonardo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadStream(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "image.png")
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	if err := os.WriteFile(image, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent, total int64
+	s := &standIn{}
+	c := newStandInClient(t, s, BackendREST, &Config{
+		OnUploadProgress: func(s, t int64) {
+			sent, total = s, t
+		},
+	})
+	ctx := context.Background()
+	if _, err := c.Upload(ctx, image); err != nil {
+		t.Fatal(err)
+	}
+	if sent != int64(len(data)) || total != int64(len(data)) {
+		t.Errorf("progress = %d/%d, want %d/%d", sent, total, len(data), len(data))
+	}
+	// The content length is known, so the body isn't sent chunked
+	if s.uploadLength <= int64(len(data)) {
+		t.Errorf("upload content length = %d, want more than %d", s.uploadLength, len(data))
+	}
+}
+
+func TestUploadMaxSize(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(image, make([]byte, 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&Config{
+		Authenticator: NewTokenAuthenticator(standInToken, "user-1"),
+		MaxUploadSize: 99,
+	})
+	if _, err := c.Upload(context.Background(), image); err == nil {
+		t.Fatal("expected error for file bigger than the maximum")
+	}
+}
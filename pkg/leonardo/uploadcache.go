@@ -0,0 +1,125 @@
+package leonardo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// UploadCache maps the content of uploaded files to their init image ids.
+type UploadCache interface {
+	// GetUpload returns the init image id of a key, or an empty string if it
+	// isn't cached.
+	GetUpload(ctx context.Context, key string) (string, error)
+	// SetUpload caches the init image id of a key, an empty id removes it.
+	SetUpload(ctx context.Context, key, id string) error
+}
+
+type uploadCache struct {
+	path string
+	lock sync.Mutex
+}
+
+// NewUploadCache creates an upload cache backed by a JSON file.
+// Concurrent writes from several processes may drop entries, which only
+// causes the files to be uploaded again.
+func NewUploadCache(path string) UploadCache {
+	return &uploadCache{
+		path: path,
+	}
+}
+
+func (c *uploadCache) load() (map[string]string, error) {
+	entries := map[string]string{}
+	b, err := readFile(c.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *uploadCache) GetUpload(ctx context.Context, key string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries, err := c.load()
+	if err != nil {
+		return "", fmt.Errorf("leonardo: couldn't read upload cache: %w", err)
+	}
+	return entries[key], nil
+}
+
+func (c *uploadCache) SetUpload(ctx context.Context, key, id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries, err := c.load()
+	if err != nil {
+		// A broken cache is replaced
+		entries = map[string]string{}
+	}
+	if id == "" {
+		delete(entries, key)
+	} else {
+		entries[key] = id
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("leonardo: couldn't marshal upload cache: %w", err)
+	}
+	if err := writeFile(c.path, b); err != nil {
+		return fmt.Errorf("leonardo: couldn't write upload cache: %w", err)
+	}
+	return nil
+}
+
+// uploadKey returns the cache key of a file for the current account, so
+// accounts sharing a cache don't reuse each other's images.
+// The team isn't part of the key because the client never acts on behalf of
+// a team: init images are always uploaded to the user's own account, with
+// the cookie session and with the API key alike. The cached id is checked to
+// still exist before it is reused anyway.
+func (c *Client) uploadKey(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("leonardo: couldn't open file: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("leonardo: couldn't hash file: %w", err)
+	}
+	return fmt.Sprintf("%s:%s", c.userID, hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// cachedUpload returns the init image id of a file uploaded before, if it
+// still exists.
+func (c *Client) cachedUpload(ctx context.Context, key string) string {
+	id, err := c.uploadCache.GetUpload(ctx, key)
+	if err != nil {
+		c.log("leonardo: %v", err)
+		return ""
+	}
+	if id == "" {
+		return ""
+	}
+	ok, err := c.backend.initImageExists(ctx, id)
+	if err != nil {
+		c.log("leonardo: couldn't check init image %s: %v", id, err)
+		return ""
+	}
+	if !ok {
+		c.log("leonardo: cached init image %s doesn't exist anymore", id)
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,56 @@
+package leonardo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadCache(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "image.png")
+	if err := os.WriteFile(image, []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// A copy with the same content is also reused
+	same := filepath.Join(dir, "same.png")
+	if err := os.WriteFile(same, []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{BackendGraphQL, BackendREST} {
+		t.Run(name, func(t *testing.T) {
+			s := &standIn{}
+			cache := NewUploadCache(filepath.Join(t.TempDir(), "uploads.json"))
+			c := newStandInClient(t, s, name, &Config{
+				UploadCache: cache,
+			})
+			ctx := context.Background()
+			upload := func(path string, uploads int) {
+				t.Helper()
+				id, err := c.Upload(ctx, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id != "init-1" {
+					t.Errorf("image id = %q, want init-1", id)
+				}
+				if s.uploads != uploads {
+					t.Errorf("uploads = %d, want %d", s.uploads, uploads)
+				}
+			}
+			upload(image, 1)
+			upload(same, 1)
+
+			// Deleted images are uploaded again
+			s.deleted = true
+			upload(image, 2)
+
+			// Other accounts don't share the cached images
+			c.userID = "user-2"
+			s.deleted = false
+			upload(image, 3)
+		})
+	}
+}
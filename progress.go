@@ -0,0 +1,59 @@
+package leonai
+
+import (
+	"log"
+	"sync"
+
+	"github.com/igolaizola/leonai/pkg/leonardo"
+)
+
+// progressStep is the upload percentage between progress logs.
+const progressStep = 25
+
+// logEvents returns an observer that logs the progress of the client.
+func logEvents() func(leonardo.Event) {
+	var lock sync.Mutex
+	uploaded := map[string]int64{}
+	return func(e leonardo.Event) {
+		switch e.Type {
+		case leonardo.EventUploadStarted:
+			log.Printf("uploading %s (%d bytes)\n", e.Path, e.Total)
+		case leonardo.EventUploadProgress:
+			if e.Total == 0 {
+				return
+			}
+			// Only log when a new step is reached
+			step := e.Sent * 100 / e.Total / progressStep * progressStep
+			lock.Lock()
+			last, ok := uploaded[e.Path]
+			uploaded[e.Path] = step
+			lock.Unlock()
+			if ok && step <= last || step == 0 {
+				return
+			}
+			log.Printf("uploaded %d%%\n", step)
+		case leonardo.EventUploadDone:
+			lock.Lock()
+			delete(uploaded, e.Path)
+			lock.Unlock()
+			if e.Cached {
+				log.Printf("image: %s (already uploaded)\n", e.ImageID)
+				return
+			}
+			log.Println("image:", e.ImageID)
+		case leonardo.EventJobSubmitted:
+			log.Println("generation:", e.GenerationID)
+			if e.Cost > 0 {
+				log.Println("cost:", e.Cost)
+			}
+		case leonardo.EventStatusChanged:
+			log.Printf("generation %s: %s\n", e.GenerationID, e.Status)
+		case leonardo.EventRetrying:
+			if e.Wait > 0 {
+				log.Printf("retrying %s in %s: %v\n", e.Operation, e.Wait, e.Err)
+				return
+			}
+			log.Printf("retrying %s: %v\n", e.Operation, e.Err)
+		}
+	}
+}
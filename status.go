@@ -0,0 +1,111 @@
+package leonai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// Status prints the status of the generations.
+func Status(ctx context.Context, cfg *Config, ids []string) error {
+	if len(ids) == 0 {
+		return errors.New("at least one generation id is required")
+	}
+	client, _, err := newClient(cfg, false)
+	if err != nil {
+		return err
+	}
+	if err := client.Start(ctx); err != nil {
+		return fmt.Errorf("couldn't start leonardo client: %w", err)
+	}
+	defer func() {
+		if err := client.Stop(ctx); err != nil {
+			log.Printf("couldn't stop leonardo client: %v\n", err)
+		}
+	}()
+	statuses, err := client.GetStatuses(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("couldn't get statuses: %w", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS")
+	for i, id := range ids {
+		status := statuses[i]
+		if status == "" {
+			status = "NOT_FOUND"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", id, status)
+	}
+	return w.Flush()
+}
+
+// Wait waits until all the generations are complete or failed and downloads
+// their videos to outputDir. It returns an error if any generation failed.
+func Wait(ctx context.Context, cfg *Config, ids []string, outputDir string) error {
+	if len(ids) == 0 {
+		return errors.New("at least one generation id is required")
+	}
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return fmt.Errorf("couldn't create output directory: %w", err)
+		}
+		// Fail before waiting if the outputs can't be written
+		for _, id := range ids {
+			if err := checkOutput(waitOutput(outputDir, id), cfg.Force); err != nil {
+				return err
+			}
+		}
+	}
+	client, httpClient, err := newClient(cfg, false)
+	if err != nil {
+		return err
+	}
+	if err := client.Start(ctx); err != nil {
+		return fmt.Errorf("couldn't start leonardo client: %w", err)
+	}
+	defer func() {
+		if err := client.Stop(ctx); err != nil {
+			log.Printf("couldn't stop leonardo client: %v\n", err)
+		}
+	}()
+	gens, err := client.WaitGenerations(ctx, ids)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, gen := range gens {
+		video, err := gen.Video()
+		if err != nil {
+			log.Printf("%s: %v\n", gen.ID, err)
+			failed++
+			continue
+		}
+		if gen.Flagged() {
+			log.Printf("%s: %s (nsfw)\n", gen.ID, video.MotionMP4URL)
+		} else {
+			log.Printf("%s: %s\n", gen.ID, video.MotionMP4URL)
+		}
+		if outputDir == "" {
+			continue
+		}
+		output := waitOutput(outputDir, gen.ID)
+		if err := download(ctx, downloadClient(cfg, httpClient), video.MotionMP4URL, output, cfg.Force); err != nil {
+			log.Printf("%s: couldn't download video: %v\n", gen.ID, err)
+			failed++
+			continue
+		}
+		log.Printf("%s: saved to %s\n", gen.ID, output)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d generations failed", failed, len(gens))
+	}
+	return nil
+}
+
+func waitOutput(dir, id string) string {
+	return filepath.Join(dir, filepath.Base(id)+".mp4")
+}
@@ -0,0 +1,51 @@
+package leonai
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// newTransport returns a clone of the default transport configured with the
+// proxy and the root certificates. If proxy is empty, the proxy is taken from
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func newTransport(proxy, caCert string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q, use http, https, socks5 or socks5h", u.Scheme)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q: missing host", proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA certificate: %w", err)
+		}
+		// Add the certificates to the system ones, so only the
+		// intercepted hosts depend on them
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		t.TLSClientConfig = &tls.Config{
+			RootCAs: pool,
+		}
+	}
+	return t, nil
+}